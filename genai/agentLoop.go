@@ -0,0 +1,136 @@
+package genai
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+var (
+	// how many rounds of tool calls the model may make for one message
+	maxToolRounds = 5
+	// how long answering one message may take, tools and model calls included
+	requestTimeout = 3 * time.Minute
+	// extra time for the final answer once a limit is hit
+	finalTurnTimeout = 30 * time.Second
+)
+
+// SetMaxToolRounds sets how many rounds of tool calls the model may make
+// before it has to answer with what it has.
+func SetMaxToolRounds(n int) {
+	maxToolRounds = n
+}
+
+// SetRequestTimeout sets the deadline for answering one message.
+func SetRequestTimeout(timeout time.Duration) {
+	requestTimeout = timeout
+}
+
+// runAgentLoop shows resp and answers its function calls, round after round,
+// until the model replies without calling a tool. When the model runs out
+// of tool rounds or the request out of time it gets one last turn to
+// answer with what it has found so far.
+func runAgentLoop(t *turn, resp *genai.GenerateContentResponse) {
+	for round := 1; ; round++ {
+		calls := renderResponse(t, resp)
+		if len(calls) == 0 {
+			return
+		}
+
+		if round > maxToolRounds {
+			logWithTime("Chat %d reached the limit of %d tool rounds", t.chatID, maxToolRounds)
+			t.finish(skippedResponses(t, calls), fmt.Sprintf("the limit of %d tool rounds was reached", maxToolRounds))
+			return
+		}
+
+		responses := handleFunctionCalls(t, calls)
+
+		if t.canceled() {
+			return
+		}
+		if t.ctx.Err() != nil {
+			logWithTime("Chat %d ran out of time after %d tool rounds", t.chatID, round)
+			t.finish(responses, "the time for this request ran out")
+			return
+		}
+
+		geminiStartTime := time.Now()
+
+		nextResp, err := t.send(responses...)
+
+		geminiProcessingTime := time.Since(geminiStartTime).Round(time.Millisecond)
+
+		logWithTime("Gemini processing completed in %v", geminiProcessingTime)
+
+		t.record("function", responses...)
+
+		if t.canceled() {
+			return
+		}
+		if err != nil {
+			logWithTime("Error sending function responses: %v", err)
+			t.bot.HandleUpdateMessage(t.chatID, t.messageID, "something went wrong!, please try again after sometime.")
+			return
+		}
+
+		if !hasNonEmptyContent(nextResp) {
+			return
+		}
+		resp = nextResp
+	}
+}
+
+// skippedResponses records calls that won't be run and answers them with
+// an error, a call can't be left without its response.
+func skippedResponses(t *turn, calls []genai.FunctionCall) []genai.Part {
+	callParts := make([]genai.Part, len(calls))
+	responses := make([]genai.Part, len(calls))
+	for i, call := range calls {
+		callParts[i] = call
+		responses[i] = toolResult{call: call, err: fmt.Errorf("not run, no more tool calls are allowed for this message")}.response()
+	}
+	t.record("model", callParts...)
+	return responses
+}
+
+// finish sends the last responses together with an instruction to answer
+// from what the model has, using fresh time since the request's may be
+// gone. Function calls in the answer are ignored, /cancel still works.
+func (t *turn) finish(responses []genai.Part, reason string) {
+	parent := t.ctx
+	ctx, cancelCause := context.WithCancelCause(context.WithoutCancel(parent))
+	defer cancelCause(nil)
+	stop := context.AfterFunc(parent, func() {
+		if isCalledOff(parent) {
+			cancelCause(context.Cause(parent))
+		}
+	})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(ctx, finalTurnTimeout)
+	defer cancel()
+	t.ctx = ctx
+
+	parts := append(responses, genai.Text(fmt.Sprintf(FinalTurnPrompt, reason)))
+	resp, err := t.send(parts...)
+
+	t.record("function", responses...)
+
+	if t.canceled() {
+		return
+	}
+	if err != nil {
+		logWithTime("Error sending final turn: %v", err)
+		t.bot.HandleUpdateMessage(t.chatID, t.messageID, "Sorry, that took too long. Please try again with a narrower question.")
+		return
+	}
+
+	if extra := renderResponse(t, resp); len(extra) > 0 {
+		logWithTime("Ignoring %d function calls in the final turn of chat %d", len(extra), t.chatID)
+	}
+	if len(responseTexts(resp)) == 0 {
+		t.bot.HandleUpdateMessage(t.chatID, t.messageID, "Sorry, I couldn't finish this request. Please try again with a narrower question.")
+	}
+}
@@ -0,0 +1,251 @@
+package genai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// fakeChat answers every message with reply, called with the parts sent.
+type fakeChat struct {
+	reply func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse
+	sent  [][]genai.Part
+}
+
+func (c *fakeChat) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	c.sent = append(c.sent, parts)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.reply(ctx, parts), nil
+}
+
+func (c *fakeChat) SendMessageStream(ctx context.Context, parts ...genai.Part) ResponseIterator {
+	panic("fakeChat doesn't stream")
+}
+
+type fakeBot struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (b *fakeBot) record(text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = append(b.messages, text)
+}
+
+func (b *fakeBot) HandleSendMessage(chatID int, text string) error {
+	b.record(text)
+	return nil
+}
+
+func (b *fakeBot) SendLoadingMessage(chatID int, text string) (int, error) {
+	return 2, nil
+}
+
+func (b *fakeBot) HandleUpdateMessage(chatID int, messageID int, text string) error {
+	b.record(text)
+	return nil
+}
+
+func (b *fakeBot) SendFileWithProgress(chatID int, filepath string) error {
+	return nil
+}
+
+func (b *fakeBot) last() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.messages) == 0 {
+		return ""
+	}
+	return b.messages[len(b.messages)-1]
+}
+
+func modelResponse(parts ...genai.Part) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: &genai.Content{Role: "model", Parts: parts}}},
+	}
+}
+
+// chat IDs of the agent loop tests, away from the other tests' chats
+var agentTestChats atomic.Int64
+
+type lookupArgs struct {
+	Query string `json:"query"`
+	Wait  int    `json:"wait,omitempty"`
+}
+
+// agentTestTurn registers a test_lookup tool and returns a turn for a
+// fresh chat talking to chat.
+func agentTestTurn(t *testing.T, ctx context.Context, chat ChatSession) (*turn, *fakeBot, *atomic.Int64) {
+	t.Helper()
+
+	var runs atomic.Int64
+	registerTestTool(t, NewTool("test_lookup", "", func(ctx context.Context, args lookupArgs) (string, error) {
+		runs.Add(1)
+		select {
+		case <-time.After(time.Duration(args.Wait) * time.Millisecond):
+			return "found " + args.Query, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}))
+
+	chatID := 9000 + int(agentTestChats.Add(1))
+	historyStore.Delete(chatID)
+	t.Cleanup(func() { historyStore.Delete(chatID) })
+
+	bot := &fakeBot{}
+	return &turn{ctx: ctx, cs: chat, bot: bot, chatID: chatID, messageID: 1}, bot, &runs
+}
+
+func lookupCall(query string, wait int) genai.FunctionCall {
+	return genai.FunctionCall{Name: "test_lookup", Args: map[string]any{"query": query, "wait": float64(wait)}}
+}
+
+func hasText(parts []genai.Part) bool {
+	for _, part := range parts {
+		if _, ok := part.(genai.Text); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAgentLoopAnswersAfterTools(t *testing.T) {
+	chat := &fakeChat{}
+	chat.reply = func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+		if len(chat.sent) < 2 {
+			return modelResponse(lookupCall(fmt.Sprint("second ", len(chat.sent)), 0))
+		}
+		return modelResponse(genai.Text("Here is the answer"))
+	}
+
+	tr, bot, runs := agentTestTurn(t, context.Background(), chat)
+	runAgentLoop(tr, modelResponse(lookupCall("first", 0)))
+
+	if runs.Load() != 2 {
+		t.Errorf("tool ran %d times, want 2", runs.Load())
+	}
+	if bot.last() != "Here is the answer" {
+		t.Errorf("last message = %q", bot.last())
+	}
+	for i, parts := range chat.sent {
+		if hasText(parts) {
+			t.Errorf("message %d asked for a final answer although no limit was hit", i)
+		}
+	}
+}
+
+func TestAgentLoopMaxToolRounds(t *testing.T) {
+	defer SetMaxToolRounds(maxToolRounds)
+	SetMaxToolRounds(2)
+
+	chat := &fakeChat{}
+	chat.reply = func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+		if hasText(parts) {
+			// even the final answer may try to call a tool
+			return modelResponse(genai.Text("Summary of what I found"), lookupCall("ignored", 0))
+		}
+		return modelResponse(lookupCall("again", 0))
+	}
+
+	tr, bot, runs := agentTestTurn(t, context.Background(), chat)
+	runAgentLoop(tr, modelResponse(lookupCall("first", 0)))
+	tr.saveHistory()
+
+	if runs.Load() != 2 {
+		t.Errorf("tool ran %d times, want 2", runs.Load())
+	}
+	if len(chat.sent) != 3 {
+		t.Fatalf("sent %d messages to the model, want 2 rounds and the final turn", len(chat.sent))
+	}
+
+	final := chat.sent[2]
+	if !hasText(final) || !strings.Contains(string(final[len(final)-1].(genai.Text)), "limit of 2 tool rounds") {
+		t.Errorf("final turn doesn't explain the limit: %v", final)
+	}
+	// the call that wasn't run still gets a response
+	if response, ok := final[0].(genai.FunctionResponse); !ok || response.Response["error"] == nil {
+		t.Errorf("skipped call wasn't answered with an error: %v", final[0])
+	}
+	if bot.last() != "Summary of what I found" {
+		t.Errorf("last message = %q", bot.last())
+	}
+
+	history, err := historyStore.Load(tr.chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls, responses := 0, 0
+	for _, entry := range history {
+		for _, part := range entry.Parts {
+			switch part.(type) {
+			case genai.FunctionCall:
+				calls++
+			case genai.FunctionResponse:
+				responses++
+			}
+		}
+	}
+	if calls != 3 || responses != 3 {
+		t.Errorf("history has %d calls and %d responses, want 3 of each", calls, responses)
+	}
+}
+
+func TestAgentLoopDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	chat := &fakeChat{}
+	chat.reply = func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+		if hasText(parts) {
+			return modelResponse(genai.Text("Partial answer"))
+		}
+		return modelResponse(lookupCall("more", 0))
+	}
+
+	tr, bot, _ := agentTestTurn(t, ctx, chat)
+	runAgentLoop(tr, modelResponse(lookupCall("slow", 5000)))
+
+	if len(chat.sent) != 1 {
+		t.Fatalf("sent %d messages to the model, want only the final turn", len(chat.sent))
+	}
+	if !strings.Contains(string(chat.sent[0][len(chat.sent[0])-1].(genai.Text)), "ran out") {
+		t.Errorf("final turn doesn't explain the deadline: %v", chat.sent[0])
+	}
+	if bot.last() != "Partial answer" {
+		t.Errorf("last message = %q", bot.last())
+	}
+}
+
+func TestAgentLoopCancel(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	chat := &fakeChat{}
+	chat.reply = func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+		return modelResponse(genai.Text("Answer nobody asked for anymore"))
+	}
+
+	tr, bot, _ := agentTestTurn(t, ctx, chat)
+	time.AfterFunc(50*time.Millisecond, func() { cancel(errCanceledByUser) })
+	runAgentLoop(tr, modelResponse(lookupCall("slow", 5000)))
+
+	if !tr.canceled() {
+		t.Fatal("turn isn't canceled")
+	}
+	if len(chat.sent) != 0 {
+		t.Errorf("sent %d messages to the model after the cancel", len(chat.sent))
+	}
+	if strings.Contains(bot.last(), "Answer") {
+		t.Errorf("last message = %q", bot.last())
+	}
+}
@@ -0,0 +1,110 @@
+package genai
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Attachment is a file the user sent along with (or instead of) a text message.
+type Attachment struct {
+	FileName string
+	MIMEType string
+	Data     []byte
+	// Document marks files sent as Telegram documents, they are kept in the
+	// chat's directory so read_file can open them later
+	Document bool
+}
+
+// Gemini only accepts these types as inline data, other text formats are
+// sent as text/plain.
+var supportedBlobTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/webp":      true,
+	"image/heic":      true,
+	"image/heif":      true,
+	"audio/wav":       true,
+	"audio/mp3":       true,
+	"audio/mpeg":      true,
+	"audio/aiff":      true,
+	"audio/aac":       true,
+	"audio/ogg":       true,
+	"audio/flac":      true,
+	"video/mp4":       true,
+	"video/mpeg":      true,
+	"video/mov":       true,
+	"video/quicktime": true,
+	"video/avi":       true,
+	"video/x-flv":     true,
+	"video/mpg":       true,
+	"video/webm":      true,
+	"video/wmv":       true,
+	"video/3gpp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+	"text/html":       true,
+	"text/css":        true,
+	"text/csv":        true,
+	"text/markdown":   true,
+	"text/xml":        true,
+	"text/rtf":        true,
+}
+
+func (a Attachment) mimeType() string {
+	mimeType := a.MIMEType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(a.FileName))
+	}
+
+	// drop parameters like "; charset=utf-8"
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = mediaType
+	}
+	return mimeType
+}
+
+func isTextType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") ||
+		mimeType == "application/json" ||
+		mimeType == "application/xml" ||
+		mimeType == "application/javascript" ||
+		mimeType == "application/x-yaml"
+}
+
+// attachmentParts turns attachments into blob parts, files Gemini can't read
+// are replaced with a note so the model can tell the user. recorded is what
+// the history keeps: a short note in place of each blob, files are only sent
+// with the message they came with.
+func attachmentParts(attachments []Attachment) (parts, recorded []genai.Part) {
+	parts = make([]genai.Part, 0, len(attachments))
+	recorded = make([]genai.Part, 0, len(attachments))
+	for _, a := range attachments {
+		mimeType := a.mimeType()
+
+		switch {
+		case supportedBlobTypes[mimeType]:
+		case isTextType(mimeType):
+			mimeType = "text/plain"
+		case a.Document:
+			// saved for read_file, the model is told about it separately
+			continue
+		default:
+			note := genai.Text(fmt.Sprintf(
+				"[The user attached %q (%s), this file type can't be read]", a.FileName, a.mimeType()))
+			parts = append(parts, note)
+			recorded = append(recorded, note)
+			continue
+		}
+
+		parts = append(parts, genai.Blob{
+			MIMEType: mimeType,
+			Data:     a.Data,
+		})
+		recorded = append(recorded, genai.Text(fmt.Sprintf("[attached %s %s]", a.mimeType(), a.FileName)))
+	}
+	return parts, recorded
+}
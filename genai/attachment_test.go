@@ -0,0 +1,68 @@
+package genai
+
+import (
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestAttachmentParts(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment Attachment
+		wantMIME   string
+		wantNote   bool
+	}{
+		{
+			name:       "photo",
+			attachment: Attachment{FileName: "photo.jpg", MIMEType: "image/jpeg"},
+			wantMIME:   "image/jpeg",
+		},
+		{
+			name:       "pdf without mime type",
+			attachment: Attachment{FileName: "report.pdf"},
+			wantMIME:   "application/pdf",
+		},
+		{
+			name:       "json is sent as text",
+			attachment: Attachment{FileName: "data.json", MIMEType: "application/json"},
+			wantMIME:   "text/plain",
+		},
+		{
+			name:       "mime parameters are dropped",
+			attachment: Attachment{FileName: "notes.txt", MIMEType: "text/plain; charset=utf-8"},
+			wantMIME:   "text/plain",
+		},
+		{
+			name:       "unsupported type",
+			attachment: Attachment{FileName: "app.exe", MIMEType: "application/x-msdownload"},
+			wantNote:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, recorded := attachmentParts([]Attachment{tt.attachment})
+			if len(parts) != 1 || len(recorded) != 1 {
+				t.Fatalf("expected 1 part, got %d and %d recorded", len(parts), len(recorded))
+			}
+			if _, ok := recorded[0].(genai.Text); !ok {
+				t.Errorf("history keeps %T instead of a note", recorded[0])
+			}
+
+			switch v := parts[0].(type) {
+			case genai.Blob:
+				if tt.wantNote {
+					t.Errorf("expected a note, got blob %s", v.MIMEType)
+				}
+				if v.MIMEType != tt.wantMIME {
+					t.Errorf("MIMEType = %q, want %q", v.MIMEType, tt.wantMIME)
+				}
+			case genai.Text:
+				if !tt.wantNote {
+					t.Errorf("expected a blob, got note %q", v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	// how many messages of a chat may wait for the one being answered
+	maxQueuedMessages = 5
+	// queued messages sent within this time of each other are answered in
+	// one turn
+	queueMergeWindow = 10 * time.Second
+	// how often the watchdog looks for stuck chats
+	watchdogInterval = time.Minute
+)
+
+var errQueueFull = errors.New("message queue is full")
+
+// SetMaxQueuedMessages sets how many messages of a chat may wait while an
+// earlier one is answered, further messages are turned away.
+func SetMaxQueuedMessages(n int) {
+	maxQueuedMessages = n
+}
+
+// queuedMessage is a message waiting for its turn. Messages merged into one
+// turn keep the loading message of each, the answer goes to the first.
+type queuedMessage struct {
+	text        string
+	messageIDs  []int
+	attachments []Attachment
+	queuedAt    time.Time
+}
+
+func queuedText(position int) string {
+	return fmt.Sprintf("⏳Queued, position %d. I'll answer once the previous messages are done...", position)
+}
+
+// stuckAfter is how long a message may be processed before the watchdog
+// gives up on it, a bit more than the request and its final turn may take.
+func stuckAfter() time.Duration {
+	return requestTimeout + finalTurnTimeout + time.Minute
+}
+
+// tryAcquireProcessing starts processing for a chat and returns its run.
+// When the chat is busy msg is queued instead and its position returned,
+// or errQueueFull if there's no room left.
+func (h *Handler) tryAcquireProcessing(chatId int, msg queuedMessage) (run int, position int, err error) {
+	h.stateMutex.Lock()
+	defer h.stateMutex.Unlock()
+
+	state, exists := h.processingState[chatId]
+	if !exists {
+		log.Printf("Creating new state for chat %d", chatId)
+
+		state = &ProcessingState{}
+		h.processingState[chatId] = state
+	}
+
+	if state.IsProcessing {
+		if len(state.Queue) >= maxQueuedMessages {
+			log.Printf("Chat %d is busy and its queue is full", chatId)
+			return 0, 0, errQueueFull
+		}
+		state.Queue = append(state.Queue, msg)
+		log.Printf("Chat %d is busy, queued message at position %d", chatId, len(state.Queue))
+		return 0, len(state.Queue), nil
+	}
+
+	log.Printf("Starting processing for chat %d", chatId)
+	state.IsProcessing = true
+	state.StartTime = time.Now()
+	state.TimeoutDuration = stuckAfter()
+	state.run = h.nextRun()
+	return state.run, 0, nil
+}
+
+// nextRun returns a run number no chat has used, stateMutex must be held.
+func (h *Handler) nextRun() int {
+	h.lastRun++
+	return h.lastRun
+}
+
+// startTurn records the start of a message of run, it returns false if the
+// watchdog has given up on the run.
+func (h *Handler) startTurn(chatId int, run int, messageID int, cancel context.CancelCauseFunc) bool {
+	h.stateMutex.Lock()
+	defer h.stateMutex.Unlock()
+
+	state, exists := h.processingState[chatId]
+	if !exists || state.run != run {
+		return false
+	}
+	state.StartTime = time.Now()
+	state.TimeoutDuration = stuckAfter()
+	state.Cancel = cancel
+	state.messageID = messageID
+	return true
+}
+
+// nextMessage takes the next message off the chat's queue for run, merged
+// with the ones sent right after it, and returns the messages still waiting.
+// When the queue is empty the chat is released and ok is false.
+func (h *Handler) nextMessage(chatId int, run int) (msg queuedMessage, waiting []queuedMessage, ok bool) {
+	h.stateMutex.Lock()
+	defer h.stateMutex.Unlock()
+
+	state, exists := h.processingState[chatId]
+	if !exists || state.run != run {
+		return queuedMessage{}, nil, false
+	}
+	state.Cancel = nil
+
+	if len(state.Queue) == 0 {
+		state.IsProcessing = false
+		return queuedMessage{}, nil, false
+	}
+
+	msg, state.Queue = popMerged(state.Queue)
+	return msg, append([]queuedMessage(nil), state.Queue...), true
+}
+
+// popMerged takes the first message off queue together with every message
+// that followed the previous one within queueMergeWindow.
+func popMerged(queue []queuedMessage) (queuedMessage, []queuedMessage) {
+	merged := queue[0]
+	texts := []string{merged.text}
+
+	n := 1
+	for ; n < len(queue); n++ {
+		next := queue[n]
+		if next.queuedAt.Sub(queue[n-1].queuedAt) > queueMergeWindow {
+			break
+		}
+		texts = append(texts, next.text)
+		merged.messageIDs = append(merged.messageIDs, next.messageIDs...)
+		merged.attachments = append(merged.attachments, next.attachments...)
+	}
+
+	if n > 1 {
+		var nonEmpty []string
+		for _, text := range texts {
+			if strings.TrimSpace(text) != "" {
+				nonEmpty = append(nonEmpty, text)
+			}
+		}
+		merged.text = strings.Join(nonEmpty, "\n\n")
+	}
+
+	return merged, queue[n:]
+}
+
+// CancelProcessing stops the message being answered in a chat. It reports
+// whether there was one. Queued messages are still answered.
+func (h *Handler) CancelProcessing(chatId int) bool {
+	h.stateMutex.Lock()
+	defer h.stateMutex.Unlock()
+
+	state, exists := h.processingState[chatId]
+	if !exists || !state.IsProcessing || state.Cancel == nil {
+		return false
+	}
+
+	log.Printf("Cancelling processing for chat %d", chatId)
+	state.Cancel(errCanceledByUser)
+	return true
+}
+
+func (h *Handler) startCleanupRoutine() {
+	ticker := time.NewTicker(watchdogInterval)
+	for range ticker.C {
+		h.watchdog()
+		h.cleanup()
+	}
+}
+
+// watchdog gives up on messages processed for longer than their
+// TimeoutDuration. Their context is cancelled and, in case the goroutine
+// ignores it, the queued messages are handed to a new worker.
+func (h *Handler) watchdog() {
+	type stuckChat struct {
+		chatID    int
+		messageID int
+		run       int
+		next      queuedMessage
+		hasNext   bool
+	}
+	var stuck []stuckChat
+
+	h.stateMutex.Lock()
+	for chatId, state := range h.processingState {
+		if !state.IsProcessing || time.Since(state.StartTime) <= state.TimeoutDuration {
+			continue
+		}
+
+		log.Printf("Chat %d has been processing since %v, giving up on it", chatId, state.StartTime)
+		if state.Cancel != nil {
+			state.Cancel(errProcessingStuck)
+			state.Cancel = nil
+		}
+		state.run = h.nextRun()
+
+		chat := stuckChat{chatID: chatId, messageID: state.messageID, run: state.run}
+		if len(state.Queue) > 0 {
+			chat.next, state.Queue = popMerged(state.Queue)
+			chat.hasNext = true
+			state.StartTime = time.Now()
+		} else {
+			state.IsProcessing = false
+		}
+		stuck = append(stuck, chat)
+	}
+	h.stateMutex.Unlock()
+
+	for _, chat := range stuck {
+		h.bot.HandleUpdateMessage(chat.chatID, chat.messageID, "Sorry, this request got stuck. Please try again.")
+		if chat.hasNext {
+			h.resumeQueue(chat.chatID, chat.run, chat.next)
+		}
+	}
+}
+
+// resumeQueue answers the queued messages of a chat the watchdog gave up on,
+// starting with next. When the pool turns the work away the chat is released
+// and the users are asked to send their messages again.
+func (h *Handler) resumeQueue(chatID int, run int, next queuedMessage) {
+	job := func() { h.workQueue(chatID, run, next) }
+	if h.pool == nil {
+		go job()
+		return
+	}
+
+	err := h.pool.Submit(job)
+	if err == nil {
+		return
+	}
+	log.Printf("Couldn't resume the queue of chat %d: %v", chatID, err)
+
+	reply := "I'm answering a lot of messages right now, please send your message again in a minute."
+	if errors.Is(err, ErrPoolClosed) {
+		reply = "I'm restarting, please send your message again in a minute."
+	}
+
+	dropped := []queuedMessage{next}
+	h.stateMutex.Lock()
+	if state, exists := h.processingState[chatID]; exists && state.run == run {
+		dropped = append(dropped, state.Queue...)
+		state.Queue = nil
+		state.IsProcessing = false
+	}
+	h.stateMutex.Unlock()
+
+	for _, msg := range dropped {
+		for _, messageID := range msg.messageIDs {
+			h.bot.HandleUpdateMessage(chatID, messageID, reply)
+		}
+	}
+}
+
+func (h *Handler) cleanup() {
+	h.stateMutex.Lock()
+	defer h.stateMutex.Unlock()
+
+	for chatId, state := range h.processingState {
+		if state.IsProcessing || len(state.Queue) > 0 {
+			continue
+		}
+		if time.Since(state.StartTime) > state.TimeoutDuration*2 {
+			delete(h.processingState, chatId)
+		}
+	}
+}
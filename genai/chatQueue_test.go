@@ -0,0 +1,207 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func queued(text string, messageID int, at time.Time) queuedMessage {
+	return queuedMessage{text: text, messageIDs: []int{messageID}, queuedAt: at}
+}
+
+func TestChatQueue(t *testing.T) {
+	defer SetMaxQueuedMessages(maxQueuedMessages)
+	SetMaxQueuedMessages(3)
+
+	h := NewHandlerWithModels(&fakeBot{}, &fakeModels{})
+	const chatID = 9101
+	start := time.Now()
+
+	run, position, err := h.tryAcquireProcessing(chatID, queued("first", 1, start))
+	if err != nil || position != 0 {
+		t.Fatalf("acquiring a free chat: position %d, err %v", position, err)
+	}
+
+	// the first two follow each other quickly, the third comes later
+	for i, msg := range []queuedMessage{
+		queued("second", 2, start.Add(time.Second)),
+		queued("third", 3, start.Add(2*time.Second)),
+		queued("fourth", 4, start.Add(time.Minute)),
+	} {
+		_, position, err := h.tryAcquireProcessing(chatID, msg)
+		if err != nil || position != i+1 {
+			t.Fatalf("queueing %q: position %d, err %v", msg.text, position, err)
+		}
+	}
+	if _, _, err := h.tryAcquireProcessing(chatID, queued("fifth", 5, start)); !errors.Is(err, errQueueFull) {
+		t.Fatalf("queueing past the limit: err %v", err)
+	}
+
+	next, waiting, ok := h.nextMessage(chatID, run)
+	if !ok {
+		t.Fatal("queue is empty")
+	}
+	if next.text != "second\n\nthird" || len(next.messageIDs) != 2 || next.messageIDs[0] != 2 {
+		t.Errorf("merged message = %q for %v", next.text, next.messageIDs)
+	}
+	if len(waiting) != 1 || waiting[0].text != "fourth" {
+		t.Errorf("waiting = %v", waiting)
+	}
+
+	if _, _, ok := h.nextMessage(chatID, run+1); ok {
+		t.Error("another run took a message")
+	}
+
+	next, _, ok = h.nextMessage(chatID, run)
+	if !ok || next.text != "fourth" {
+		t.Fatalf("next = %q, %v", next.text, ok)
+	}
+	if _, _, ok := h.nextMessage(chatID, run); ok {
+		t.Fatal("empty queue returned a message")
+	}
+
+	// released, the next message is processed right away
+	if _, position, err := h.tryAcquireProcessing(chatID, queued("again", 6, start)); err != nil || position != 0 {
+		t.Errorf("acquiring a released chat: position %d, err %v", position, err)
+	}
+}
+
+func TestCancelProcessing(t *testing.T) {
+	h := NewHandlerWithModels(&fakeBot{}, &fakeModels{})
+	const chatID = 9102
+	if h.CancelProcessing(chatID) {
+		t.Error("cancelled a chat with nothing to cancel")
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	run, _, _ := h.tryAcquireProcessing(chatID, queued("hello", 1, time.Now()))
+	if !h.startTurn(chatID, run, 1, cancel) {
+		t.Fatal("couldn't start a turn of the current run")
+	}
+	if !h.CancelProcessing(chatID) {
+		t.Fatal("CancelProcessing = false while processing")
+	}
+	if !errors.Is(context.Cause(ctx), errCanceledByUser) {
+		t.Errorf("cause = %v", context.Cause(ctx))
+	}
+
+	h.nextMessage(chatID, run)
+	if h.CancelProcessing(chatID) {
+		t.Error("cancelled a released chat")
+	}
+}
+
+func TestWatchdogReleasesStuckChat(t *testing.T) {
+	bot := &fakeBot{}
+	h := NewHandlerWithModels(bot, &fakeModels{})
+	const chatID = 9103
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	run, _, _ := h.tryAcquireProcessing(chatID, queued("hello", 1, time.Now()))
+	h.startTurn(chatID, run, 1, cancel)
+
+	h.watchdog()
+	if ctx.Err() != nil {
+		t.Fatal("watchdog cancelled a request within its time")
+	}
+
+	h.stateMutex.Lock()
+	h.processingState[chatID].StartTime = time.Now().Add(-stuckAfter() - time.Second)
+	h.stateMutex.Unlock()
+
+	h.watchdog()
+	if !errors.Is(context.Cause(ctx), errProcessingStuck) {
+		t.Errorf("cause = %v", context.Cause(ctx))
+	}
+	if bot.last() == "" {
+		t.Error("the user wasn't told about the stuck request")
+	}
+
+	// the stuck goroutine can't take messages of the chat anymore
+	if h.startTurn(chatID, run, 1, cancel) {
+		t.Error("stuck run started another turn")
+	}
+	if _, position, err := h.tryAcquireProcessing(chatID, queued("again", 2, time.Now())); err != nil || position != 0 {
+		t.Errorf("chat still busy after the watchdog: position %d, err %v", position, err)
+	}
+}
+
+func TestRunsNotReusedAfterCleanup(t *testing.T) {
+	h := NewHandlerWithModels(&fakeBot{}, &fakeModels{})
+	const chatID = 9104
+
+	stale, _, _ := h.tryAcquireProcessing(chatID, queued("hello", 1, time.Now()))
+	h.stateMutex.Lock()
+	h.processingState[chatID].StartTime = time.Now().Add(-stuckAfter() - time.Second)
+	h.stateMutex.Unlock()
+
+	// the watchdog gives up on the run, then cleanup forgets the chat
+	h.watchdog()
+	h.stateMutex.Lock()
+	h.processingState[chatID].StartTime = time.Now().Add(-3 * stuckAfter())
+	h.processingState[chatID].TimeoutDuration = stuckAfter()
+	h.stateMutex.Unlock()
+	h.cleanup()
+	if _, ok := h.processingState[chatID]; ok {
+		t.Fatal("cleanup kept the idle chat")
+	}
+
+	run, _, _ := h.tryAcquireProcessing(chatID, queued("new", 2, time.Now()))
+	if run == stale {
+		t.Fatalf("recreated chat reused run %d", run)
+	}
+	h.tryAcquireProcessing(chatID, queued("queued", 3, time.Now()))
+
+	if h.startTurn(chatID, stale, 1, nil) {
+		t.Error("stale run started a turn")
+	}
+	if _, _, ok := h.nextMessage(chatID, stale); ok {
+		t.Error("stale run took a queued message")
+	}
+	if _, _, ok := h.nextMessage(chatID, run); !ok {
+		t.Error("current run lost its queued message")
+	}
+}
+
+func TestWatchdogResumesQueueInPool(t *testing.T) {
+	bot := &fakeBot{}
+	h := NewHandlerWithModels(bot, &fakeModels{})
+	pool := NewWorkerPool(1, 0)
+	h.SetWorkerPool(pool)
+	const chatID = 9105
+
+	// every worker is busy so the queue can't be resumed
+	release := make(chan struct{})
+	defer close(release)
+	if err := pool.Submit(func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	h.tryAcquireProcessing(chatID, queued("stuck", 1, now))
+	h.tryAcquireProcessing(chatID, queued("first", 2, now))
+	h.tryAcquireProcessing(chatID, queued("second", 3, now.Add(time.Minute)))
+	h.stateMutex.Lock()
+	h.processingState[chatID].StartTime = now.Add(-stuckAfter() - time.Second)
+	h.stateMutex.Unlock()
+
+	h.watchdog()
+
+	turnedAway := 0
+	for _, message := range bot.messages {
+		if strings.Contains(message, "send your message again") {
+			turnedAway++
+		}
+	}
+	if turnedAway != 2 {
+		t.Errorf("%d queued messages turned away, want 2: %q", turnedAway, bot.messages)
+	}
+	if _, position, err := h.tryAcquireProcessing(chatID, queued("again", 4, time.Now())); err != nil || position != 0 {
+		t.Errorf("chat still busy after its queue was turned away: position %d, err %v", position, err)
+	}
+}
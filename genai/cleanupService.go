@@ -10,6 +10,10 @@ import (
 	"time"
 )
 
+// fileLifetime is how long uploads and created files are kept, the cleanup
+// deletes them on its first run after that.
+const fileLifetime = time.Hour
+
 type CleanupService struct {
 	interval  time.Duration
 	ctx       context.Context
@@ -75,38 +79,73 @@ func (cs *CleanupService) performCleanup() error {
 		return fmt.Errorf("error reading directory: %v", err)
 	}
 
-	thresholdTime := time.Now().Add(-time.Hour)
+	thresholdTime := time.Now().Add(-fileLifetime)
 
 	// Small BUFFER CHANNEL as semaphore to limit concurrent deletions
 	semaphore := make(chan struct{}, 3)
 	var wg sync.WaitGroup
 
+	var chatDirs []string
+
 	for _, file := range files {
-		info, err := file.Info()
-		if err != nil {
-			log.Printf("Warning: couldn't get info for file %s: %v\n", file.Name(), err)
+		// per chat directories, see chatFileDir
+		if file.IsDir() {
+			chatDir := filepath.Join(cs.dirPath, file.Name())
+			chatDirs = append(chatDirs, chatDir)
+			cs.removeOldFiles(chatDir, thresholdTime, semaphore, &wg)
 			continue
 		}
 
-		if info.ModTime().Before(thresholdTime) {
-			wg.Add(1)
-			go func(f os.DirEntry) {
-				defer wg.Done()
-				semaphore <- struct{}{}        // Acquire
-				defer func() { <-semaphore }() // Release
-
-				filePath := filepath.Join(cs.dirPath, f.Name())
-				if err := os.Remove(filePath); err != nil {
-					log.Printf("Warning: couldn't delete file %s: %v\n", f.Name(), err)
-				}
-			}(file)
-		}
+		cs.removeIfOld(cs.dirPath, file, thresholdTime, semaphore, &wg)
 	}
 
 	wg.Wait()
+
+	// only succeeds for directories that are empty now
+	for _, dir := range chatDirs {
+		os.Remove(dir)
+	}
+
 	return nil
 }
 
+func (cs *CleanupService) removeOldFiles(dir string, thresholdTime time.Time, semaphore chan struct{}, wg *sync.WaitGroup) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: couldn't read directory %s: %v\n", dir, err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		cs.removeIfOld(dir, file, thresholdTime, semaphore, wg)
+	}
+}
+
+func (cs *CleanupService) removeIfOld(dir string, file os.DirEntry, thresholdTime time.Time, semaphore chan struct{}, wg *sync.WaitGroup) {
+	info, err := file.Info()
+	if err != nil {
+		log.Printf("Warning: couldn't get info for file %s: %v\n", file.Name(), err)
+		return
+	}
+
+	if info.ModTime().Before(thresholdTime) {
+		wg.Add(1)
+		go func(f os.DirEntry) {
+			defer wg.Done()
+			semaphore <- struct{}{}        // Acquire
+			defer func() { <-semaphore }() // Release
+
+			filePath := filepath.Join(dir, f.Name())
+			if err := os.Remove(filePath); err != nil {
+				log.Printf("Warning: couldn't delete file %s: %v\n", f.Name(), err)
+			}
+		}(file)
+	}
+}
+
 func (cs *CleanupService) Stop() {
 	cs.mu.Lock()
 	defer cs.mu.Unlock()
@@ -116,4 +155,3 @@ func (cs *CleanupService) Stop() {
 		cs.isRunning = false
 	}
 }
-
@@ -0,0 +1,290 @@
+package genai
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Config is the configuration of the chat model, loaded by LoadConfig from
+// a TOML file and the environment:
+//
+//	model = "gemini-2.0-flash-exp"
+//	system_prompt_file = "system_prompt.md"
+//
+//	[generation]
+//	temperature = 0.7
+//	top_p = 0.95
+//	max_output_tokens = 8192
+//
+//	[safety]
+//	harassment = "none"
+//	hate_speech = "only_high"
+//
+//	[tools]
+//	create_file = false
+//
+// Generation parameters that aren't set are left to the model's defaults.
+type Config struct {
+	Model string `toml:"model"`
+	// SystemPromptFile replaces InitialSystemPrompt with the file's text
+	SystemPromptFile string `toml:"system_prompt_file"`
+
+	Generation GenerationConfig `toml:"generation"`
+	// Safety maps harm categories to block thresholds, see safetyCategories
+	// and safetyThresholds for the names
+	Safety map[string]string `toml:"safety"`
+	// Tools enables or disables registered tools by name, tools that aren't
+	// listed are enabled
+	Tools map[string]bool `toml:"tools"`
+
+	systemPrompt string
+}
+
+type GenerationConfig struct {
+	Temperature     *float32 `toml:"temperature"`
+	TopP            *float32 `toml:"top_p"`
+	MaxOutputTokens *int32   `toml:"max_output_tokens"`
+}
+
+var safetyCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+var safetyThresholds = map[string]genai.HarmBlockThreshold{
+	"none":             genai.HarmBlockNone,
+	"only_high":        genai.HarmBlockOnlyHigh,
+	"medium_and_above": genai.HarmBlockMediumAndAbove,
+	"low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+// DefaultConfig is the configuration used when nothing is set: every safety
+// filter off and every tool enabled.
+func DefaultConfig() Config {
+	safety := make(map[string]string, len(safetyCategories))
+	for category := range safetyCategories {
+		safety[category] = "none"
+	}
+
+	return Config{
+		Model:        modelName,
+		Safety:       safety,
+		Tools:        map[string]bool{},
+		systemPrompt: InitialSystemPrompt,
+	}
+}
+
+// LoadConfig reads the config file at path, if path isn't empty, over the
+// defaults and applies the environment overrides:
+//
+//	GEMINI_MODEL, GEMINI_TEMPERATURE, GEMINI_TOP_P, GEMINI_MAX_OUTPUT_TOKENS
+//	SYSTEM_PROMPT_FILE
+//	SAFETY_<CATEGORY>, e.g. SAFETY_HATE_SPEECH=only_high
+//	DISABLED_TOOLS, a comma separated list of tool names
+//
+// The result is validated.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		meta, err := toml.DecodeFile(path, &cfg)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading config %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return Config{}, fmt.Errorf("config %s: unknown key %s", path, undecoded[0])
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.SystemPromptFile != "" {
+		prompt, err := os.ReadFile(cfg.SystemPromptFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading system prompt: %w", err)
+		}
+		cfg.systemPrompt = string(prompt)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	if v, ok := lookup("GEMINI_MODEL"); ok {
+		c.Model = v
+	}
+	if v, ok := lookup("SYSTEM_PROMPT_FILE"); ok {
+		c.SystemPromptFile = v
+	}
+
+	for name, field := range map[string]**float32{
+		"GEMINI_TEMPERATURE": &c.Generation.Temperature,
+		"GEMINI_TOP_P":       &c.Generation.TopP,
+	} {
+		if v, ok := lookup(name); ok {
+			f, err := strconv.ParseFloat(v, 32)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, v)
+			}
+			*field = genai.Ptr(float32(f))
+		}
+	}
+	if v, ok := lookup("GEMINI_MAX_OUTPUT_TOKENS"); ok {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid GEMINI_MAX_OUTPUT_TOKENS %q", v)
+		}
+		c.Generation.MaxOutputTokens = genai.Ptr(int32(n))
+	}
+
+	for category := range safetyCategories {
+		if v, ok := lookup("SAFETY_" + strings.ToUpper(category)); ok {
+			c.Safety[category] = strings.ToLower(v)
+		}
+	}
+
+	if v, ok := lookup("DISABLED_TOOLS"); ok {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Tools[name] = false
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the values are ones Gemini accepts and the names refer to
+// known safety categories, thresholds and registered tools.
+func (c Config) Validate() error {
+	if strings.TrimSpace(c.Model) == "" {
+		return fmt.Errorf("config: model is empty")
+	}
+
+	g := c.Generation
+	if g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > 2) {
+		return fmt.Errorf("config: temperature %v is not between 0 and 2", *g.Temperature)
+	}
+	if g.TopP != nil && (*g.TopP < 0 || *g.TopP > 1) {
+		return fmt.Errorf("config: top_p %v is not between 0 and 1", *g.TopP)
+	}
+	if g.MaxOutputTokens != nil && *g.MaxOutputTokens <= 0 {
+		return fmt.Errorf("config: max_output_tokens must be positive, got %d", *g.MaxOutputTokens)
+	}
+
+	for category, threshold := range c.Safety {
+		if _, ok := safetyCategories[category]; !ok {
+			return fmt.Errorf("config: unknown safety category %q, expected one of %s", category, strings.Join(sortedKeys(safetyCategories), ", "))
+		}
+		if _, ok := safetyThresholds[threshold]; !ok {
+			return fmt.Errorf("config: unknown safety threshold %q for %s, expected one of %s", threshold, category, strings.Join(sortedKeys(safetyThresholds), ", "))
+		}
+	}
+
+	for name := range c.Tools {
+		if _, ok := registry[name]; !ok {
+			return fmt.Errorf("config: unknown tool %q", name)
+		}
+	}
+
+	if strings.TrimSpace(c.SystemPrompt()) == "" {
+		return fmt.Errorf("config: system prompt is empty")
+	}
+	return nil
+}
+
+// SystemPrompt is the text of SystemPromptFile, or InitialSystemPrompt.
+func (c Config) SystemPrompt() string {
+	if c.systemPrompt == "" {
+		return InitialSystemPrompt
+	}
+	return c.systemPrompt
+}
+
+// DisabledTools lists the tools turned off, sorted by name.
+func (c Config) DisabledTools() []string {
+	var disabled []string
+	for name, enabled := range c.Tools {
+		if !enabled {
+			disabled = append(disabled, name)
+		}
+	}
+	sort.Strings(disabled)
+	return disabled
+}
+
+func (c Config) safetySettings() []*genai.SafetySetting {
+	settings := make([]*genai.SafetySetting, 0, len(c.Safety))
+	for _, category := range sortedKeys(c.Safety) {
+		settings = append(settings, &genai.SafetySetting{
+			Category:  safetyCategories[category],
+			Threshold: safetyThresholds[c.Safety[category]],
+		})
+	}
+	return settings
+}
+
+// Summary describes the config in one line for the startup log.
+func (c Config) Summary() string {
+	param := func(v any, set bool) string {
+		if !set {
+			return "default"
+		}
+		return fmt.Sprint(v)
+	}
+
+	var safety []string
+	for _, category := range sortedKeys(c.Safety) {
+		safety = append(safety, category+"="+c.Safety[category])
+	}
+
+	prompt := "built-in"
+	if c.SystemPromptFile != "" {
+		prompt = fmt.Sprintf("%s (%d bytes)", c.SystemPromptFile, len(c.systemPrompt))
+	}
+
+	disabled := "none"
+	if tools := c.DisabledTools(); len(tools) > 0 {
+		disabled = strings.Join(tools, ",")
+	}
+
+	g := c.Generation
+	return fmt.Sprintf("model=%s temperature=%s top_p=%s max_output_tokens=%s safety=[%s] system_prompt=%s disabled_tools=%s",
+		c.Model,
+		param(deref(g.Temperature), g.Temperature != nil),
+		param(deref(g.TopP), g.TopP != nil),
+		param(deref(g.MaxOutputTokens), g.MaxOutputTokens != nil),
+		strings.Join(safety, " "),
+		prompt,
+		disabled,
+	)
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,167 @@
+package genai
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Model != modelName || cfg.SystemPrompt() != InitialSystemPrompt {
+		t.Errorf("model %q, prompt changed", cfg.Model)
+	}
+	if cfg.Generation.Temperature != nil || cfg.Generation.TopP != nil || cfg.Generation.MaxOutputTokens != nil {
+		t.Errorf("generation parameters set by default: %+v", cfg.Generation)
+	}
+	settings := cfg.safetySettings()
+	if len(settings) != 4 {
+		t.Fatalf("%d safety settings, want 4", len(settings))
+	}
+	for _, setting := range settings {
+		if setting.Threshold != genai.HarmBlockNone {
+			t.Errorf("%v threshold = %v", setting.Category, setting.Threshold)
+		}
+	}
+	if len(cfg.DisabledTools()) != 0 {
+		t.Errorf("disabled tools = %v", cfg.DisabledTools())
+	}
+}
+
+func TestLoadConfigFileAndEnv(t *testing.T) {
+	promptPath := filepath.Join(t.TempDir(), "prompt.md")
+	if err := os.WriteFile(promptPath, []byte("You are a test bot."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, `
+model = "gemini-1.5-pro"
+system_prompt_file = "`+filepath.ToSlash(promptPath)+`"
+
+[generation]
+temperature = 0.5
+top_p = 0.9
+
+[safety]
+hate_speech = "only_high"
+
+[tools]
+create_file = false
+`)
+
+	t.Setenv("GEMINI_TEMPERATURE", "1.25")
+	t.Setenv("GEMINI_MAX_OUTPUT_TOKENS", "2048")
+	t.Setenv("SAFETY_HARASSMENT", "LOW_AND_ABOVE")
+	t.Setenv("DISABLED_TOOLS", "web_search, ")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Model != "gemini-1.5-pro" {
+		t.Errorf("model = %q", cfg.Model)
+	}
+	if cfg.SystemPrompt() != "You are a test bot." {
+		t.Errorf("system prompt = %q", cfg.SystemPrompt())
+	}
+	g := cfg.Generation
+	if *g.Temperature != 1.25 || *g.TopP != 0.9 || *g.MaxOutputTokens != 2048 {
+		t.Errorf("generation = %v %v %v", *g.Temperature, *g.TopP, *g.MaxOutputTokens)
+	}
+	// the file only overrides the categories it lists
+	want := map[string]string{"harassment": "low_and_above", "hate_speech": "only_high", "sexually_explicit": "none", "dangerous_content": "none"}
+	for category, threshold := range want {
+		if cfg.Safety[category] != threshold {
+			t.Errorf("safety %s = %q, want %q", category, cfg.Safety[category], threshold)
+		}
+	}
+	if got := strings.Join(cfg.DisabledTools(), ","); got != "create_file,web_search" {
+		t.Errorf("disabled tools = %s", got)
+	}
+
+	summary := cfg.Summary()
+	for _, part := range []string{"model=gemini-1.5-pro", "temperature=1.25", "max_output_tokens=2048", "hate_speech=only_high", "disabled_tools=create_file,web_search"} {
+		if !strings.Contains(summary, part) {
+			t.Errorf("summary %q doesn't contain %q", summary, part)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"unknown key", `modle = "x"`, "unknown key modle"},
+		{"empty model", `model = " "`, "model is empty"},
+		{"temperature", "[generation]\ntemperature = 3.0", "temperature 3 is not between 0 and 2"},
+		{"top_p", "[generation]\ntop_p = -0.1", "top_p"},
+		{"max tokens", "[generation]\nmax_output_tokens = 0", "max_output_tokens must be positive"},
+		{"category", "[safety]\nviolence = \"none\"", `unknown safety category "violence"`},
+		{"threshold", "[safety]\nharassment = \"all\"", `unknown safety threshold "all"`},
+		{"tool", "[tools]\nsend_email = true", `unknown tool "send_email"`},
+		{"prompt file", `system_prompt_file = "does/not/exist.md"`, "reading system prompt"},
+		{"syntax", `model = `, "reading config"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("GEMINI_TOP_P", "high")
+	if _, err := LoadConfig(""); err == nil || !strings.Contains(err.Error(), "GEMINI_TOP_P") {
+		t.Errorf("invalid env override: err = %v", err)
+	}
+}
+
+func TestDisabledTools(t *testing.T) {
+	defer SetDisabledTools()
+	SetDisabledTools("web_search")
+
+	for _, declaration := range toolDeclarations().FunctionDeclarations {
+		if declaration.Name == "web_search" {
+			t.Error("disabled tool is declared to the model")
+		}
+	}
+	if _, err := getTool("web_search"); err == nil {
+		t.Error("disabled tool can still be called")
+	}
+	if _, err := getTool("read_file"); err != nil {
+		t.Errorf("enabled tool: %v", err)
+	}
+}
+
+func TestChatToolsAllDisabled(t *testing.T) {
+	defer SetDisabledTools()
+	if len(chatTools()) != 1 {
+		t.Fatalf("chat tools = %v, want the enabled tools", chatTools())
+	}
+
+	SetDisabledTools(registryOrder...)
+	if tools := chatTools(); tools != nil {
+		t.Errorf("every tool disabled, chat tools = %v", tools)
+	}
+}
@@ -0,0 +1,119 @@
+package genai
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ledongthuc/pdf"
+)
+
+// pdfText extracts the plain text of every page of a PDF.
+func pdfText(data []byte) (text string, err error) {
+	// the pdf package panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open pdf: %v", err)
+	}
+
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract pdf text: %v", err)
+	}
+
+	var sb strings.Builder
+	if _, err := io.Copy(&sb, plain); err != nil {
+		return "", fmt.Errorf("failed to extract pdf text: %v", err)
+	}
+
+	return sb.String(), nil
+}
+
+// docxText extracts the paragraphs of a Word document.
+func docxText(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open docx: %v", err)
+	}
+
+	for _, f := range archive.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to open docx: %v", err)
+		}
+		defer rc.Close()
+
+		var sb strings.Builder
+		decoder := xml.NewDecoder(io.LimitReader(rc, 10<<20))
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to parse docx: %v", err)
+			}
+
+			switch t := token.(type) {
+			case xml.CharData:
+				sb.Write(t)
+			case xml.EndElement:
+				// w:p is a paragraph
+				if t.Name.Local == "p" {
+					sb.WriteByte('\n')
+				}
+			}
+		}
+		return sb.String(), nil
+	}
+
+	return "", fmt.Errorf("not a docx file")
+}
+
+// htmlText returns the visible text of an HTML document.
+func htmlText(data []byte) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse html: %v", err)
+	}
+
+	doc.Find("script, style, noscript").Remove()
+	// keep block elements on their own lines
+	doc.Find("br, p, div, li, tr, pre, blockquote, h1, h2, h3, h4, h5, h6").AppendHtml("\n")
+
+	var lines []string
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		if line = trimContent(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// truncateText cuts text to at most max bytes without splitting a character.
+func truncateText(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
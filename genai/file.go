@@ -2,71 +2,210 @@ package genai
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/google/generative-ai-go/genai"
 )
 
-func createFile(ctx context.Context, funCall genai.FunctionCall) (string, error) {
+const (
+	maxReadFileSize  = 10 << 20 // 10 MB
+	maxReadFileChars = 100000
+)
 
-	// 1. Get `fileName` from args
-	fileName, ok := funCall.Args["file_name"].(string)
-	if !ok || fileName == "" {
-		return "", fmt.Errorf("invalid or missing file_name argument")
-	}
+// extensions that are read as they are
+var plainTextExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".csv": true, ".tsv": true,
+	".json": true, ".xml": true, ".yaml": true, ".yml": true, ".toml": true,
+	".ini": true, ".log": true, ".go": true, ".py": true, ".js": true,
+	".ts": true, ".java": true, ".c": true, ".h": true, ".cpp": true,
+	".rs": true, ".rb": true, ".php": true, ".sh": true, ".sql": true,
+	".css": true, ".kt": true, ".swift": true,
+}
+
+// extensions create_file can write, the content is validated per format
+var createFileExtensions = []string{
+	"txt", "md", "csv", "json", "html", "pdf", "xml", "yaml",
+	"go", "py", "js", "ts", "java", "c", "cpp", "h", "rs", "rb", "php", "sh", "sql", "css", "kt", "swift",
+}
+
+func init() {
+	Register(NewTool("read_file",
+		"Read the text of a file the user uploaded or a file created earlier in this chat (txt, md, csv, json, html, pdf, docx and source code)",
+		readFile))
+	Register(NewTool("create_file",
+		"Creates a file for given content and filename and sends it to the user",
+		createFile))
+}
+
+type createFileArgs struct {
+	FileName      string `json:"file_name" description:"file name without the extension for example : rust_book , the extension is added from file_extension"`
+	FileContent   string `json:"file_content" description:"File content which will be written to file it should be string. For pdf write plain text or markdown (headings, lists, code blocks), it will be rendered, Latin characters only"`
+	FileExtension string `json:"file_extension,omitempty" description:"Format of the file, defaults to txt. json must be valid JSON and csv rows must all have the same number of columns"`
+}
+
+func (createFileArgs) adjustSchema(schema *genai.Schema) {
+	extension := schema.Properties["file_extension"]
+	extension.Format = "enum"
+	extension.Enum = createFileExtensions
+}
+
+func createFile(ctx context.Context, args createFileArgs) (string, error) {
+	fileName := args.FileName
 
-	// 2. Get `fileContent` from args
-	fileContent, ok := funCall.Args["file_content"].(string)
-	if !ok || fileContent == "" {
-		return "", fmt.Errorf("invalid or missing file_content argument")
+	// 1. Get `fileExtension` from args, plain text unless asked otherwise,
+	// it's one of createFileExtensions
+	ext := "txt"
+	if v := args.FileExtension; v != "" {
+		ext = v
 	}
 
-	// 3. Get working dir
-	dir, err := os.Getwd()
-	if err != nil {
-		return "", fmt.Errorf("failed to get working directory: %v", err)
+	// the model sometimes includes the extension in the name anyway
+	if strings.HasSuffix(strings.ToLower(fileName), "."+ext) {
+		fileName = fileName[:len(fileName)-len(ext)-1]
 	}
 
-	// 4. Create working directory for files
-	fileDir := filepath.Join(dir, "synapse_files")
-	err = os.MkdirAll(fileDir, os.ModePerm)
+	// 2. Validate and encode content for the format
+	data, err := fileData(ext, args.FileContent)
 	if err != nil {
-		return "", fmt.Errorf("failed to create directory: %v", err)
+		return "", fmt.Errorf("invalid %s content: %v", ext, err)
 	}
 
-	// 5. Construct file path
-	filePath := filepath.Join(fileDir, fileName)
-	filePath = filePath + ".txt"
-
-	// 6. Create file
-	file, err := os.Create(filePath)
+	// 3. Construct file path inside the chat's directory
+	filePath, err := sandboxPath(ctx, fileName+"."+ext)
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
+		return "", err
 	}
-	defer file.Close()
 
-	// 7. Write content to file
-	_, err = file.WriteString(fileContent)
-	if err != nil {
+	// 4. Write content to file
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
 		return "", fmt.Errorf("failed to write to file: %v", err)
 	}
 
 	return fmt.Sprintf("File created successfully at %s", filePath), nil
 }
 
-func readFile(ctx context.Context, fc genai.FunctionCall) (string, error) {
-	filename, ok := fc.Args["file_name"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid or missing file_name argument")
+// fileData checks content is well formed for ext and returns the bytes to
+// write, for pdf the content is rendered as text/markdown.
+func fileData(ext string, content string) ([]byte, error) {
+	switch ext {
+	case "json":
+		var v any
+		if err := json.Unmarshal([]byte(content), &v); err != nil {
+			return nil, err
+		}
+	case "csv":
+		reader := csv.NewReader(strings.NewReader(content))
+		// every row must have as many fields as the first one
+		reader.FieldsPerRecord = 0
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("no rows")
+		}
+	case "xml":
+		decoder := xml.NewDecoder(strings.NewReader(content))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	case "html":
+		if !strings.Contains(strings.ToLower(content), "<html") {
+			content = "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"></head>\n<body>\n" + content + "\n</body>\n</html>\n"
+		}
+	case "pdf":
+		if err := checkWinAnsi(content); err != nil {
+			return nil, err
+		}
+		return renderPDF(content), nil
 	}
 
-	filePath := "synapse_files" + "/" + filename
+	return []byte(content), nil
+}
 
-	content, err := os.ReadFile(filePath)
+type readFileArgs struct {
+	FileName string `json:"file_name" description:"Name of the file to read including the extension, for example : report.pdf"`
+}
+
+func readFile(ctx context.Context, args readFileArgs) (string, error) {
+	filename := args.FileName
+
+	filePath, err := sandboxPath(ctx, filename)
+	if err != nil {
+		return "", err
+	}
+
+	// Lstat so a symlink can't point outside the sandbox
+	info, err := os.Lstat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to read file: %s doesn't exist, uploads and created files are only kept for a while so it may have expired", filename)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("failed to read file: %s is not a regular file", filename)
+	}
+	if info.Size() > maxReadFileSize {
+		return "", fmt.Errorf("failed to read file: %s is larger than %d MB", filename, maxReadFileSize>>20)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, maxReadFileSize))
 	if err != nil {
 		return "", fmt.Errorf("failed to read file: %v", err)
 	}
-	return string(content), nil
+
+	text, err := fileText(filename, content)
+	if err != nil {
+		return "", err
+	}
+
+	if len(text) > maxReadFileChars {
+		text = truncateText(text, maxReadFileChars) + "\n\n[file truncated]"
+	}
+
+	return text, nil
+}
+
+// fileText extracts the text of a file based on its extension.
+func fileText(filename string, content []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	switch {
+	case ext == ".pdf":
+		return pdfText(content)
+	case ext == ".docx":
+		return docxText(content)
+	case ext == ".html" || ext == ".htm":
+		return htmlText(content)
+	case plainTextExtensions[ext], ext == "" && utf8.Valid(content):
+		if !utf8.Valid(content) {
+			return "", fmt.Errorf("failed to read file: %s is not valid UTF-8 text", filename)
+		}
+		return string(content), nil
+	default:
+		return "", fmt.Errorf("failed to read file: unsupported file type %q", ext)
+	}
 }
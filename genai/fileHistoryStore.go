@@ -0,0 +1,153 @@
+package genai
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileHistoryStore keeps every chat's history as a JSON file in dirPath so
+// conversations survive restarts.
+type FileHistoryStore struct {
+	dirPath string
+	mu      sync.Mutex
+}
+
+func NewFileHistoryStore(dirPath string) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	return &FileHistoryStore{
+		dirPath: dirPath,
+	}, nil
+}
+
+func (s *FileHistoryStore) path(chatID int) string {
+	return filepath.Join(s.dirPath, strconv.Itoa(chatID)+".json")
+}
+
+func (s *FileHistoryStore) read(chatID int) (*ChatHistory, error) {
+	data, err := os.ReadFile(s.path(chatID))
+	if os.IsNotExist(err) {
+		return &ChatHistory{
+			ChatID:  chatID,
+			History: []Conversation{},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+
+	var history ChatHistory
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to decode history: %v", err)
+	}
+	return &history, nil
+}
+
+// write replaces the history file through a rename so a crash never leaves
+// a half written file behind.
+func (s *FileHistoryStore) write(history *ChatHistory) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to encode history: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dirPath, "history-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history: %v", err)
+	}
+
+	return os.Rename(tmp.Name(), s.path(history.ChatID))
+}
+
+func (s *FileHistoryStore) Load(chatID int) ([]Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.read(chatID)
+	if err != nil {
+		return nil, err
+	}
+	return history.History, nil
+}
+
+func (s *FileHistoryStore) Append(chatID int, entries ...Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.read(chatID)
+	if err != nil {
+		return err
+	}
+
+	history.History = append(history.History, entries...)
+	history.TimeStamps = time.Now()
+	return s.write(history)
+}
+
+func (s *FileHistoryStore) Trim(chatID int, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.read(chatID)
+	if err != nil {
+		return err
+	}
+
+	if len(history.History) <= keep {
+		return nil
+	}
+
+	history.trim(keep)
+	return s.write(history)
+}
+
+func (s *FileHistoryStore) Delete(chatID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(chatID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete history: %v", err)
+	}
+	return nil
+}
+
+func (s *FileHistoryStore) LoadSummary(chatID int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.read(chatID)
+	if err != nil {
+		return "", err
+	}
+	return history.Summary, nil
+}
+
+func (s *FileHistoryStore) SaveSummary(chatID int, summary string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.read(chatID)
+	if err != nil {
+		return err
+	}
+
+	history.Summary = summary
+	return s.write(history)
+}
@@ -13,11 +13,18 @@ import (
 
 const (
 	testDir     = "synapse_files"
+	testChatID  = 42
 	testFile    = "test_file"
 	testFileExt = "test_file.txt"
 	testContent = "test content"
 )
 
+var testChatDir = filepath.Join(testDir, "42")
+
+func testChatContext() context.Context {
+	return context.WithValue(context.Background(), "chatId", testChatID)
+}
+
 func TestMain(m *testing.M) {
 	// Setup
 	os.MkdirAll(testDir, os.ModePerm)
@@ -75,11 +82,40 @@ func TestFileOperations(t *testing.T) {
 			op:      "read_file",
 			file:    "nonexistent.txt",
 			wantErr: true,
-			errMsg:  "failed to read file",
+			errMsg:  "nonexistent.txt doesn't exist, uploads and created files are only kept for a while",
+		},
+		{
+			name:    "read with path traversal",
+			op:      "read_file",
+			file:    "../" + testFileExt,
+			wantErr: true,
+			errMsg:  "paths are not allowed",
+		},
+		{
+			name:    "read absolute path",
+			op:      "read_file",
+			file:    "/etc/passwd",
+			wantErr: true,
+			errMsg:  "paths are not allowed",
+		},
+		{
+			name:    "read parent directory",
+			op:      "read_file",
+			file:    "..",
+			wantErr: true,
+			errMsg:  "invalid file name",
+		},
+		{
+			name:    "create with path traversal",
+			op:      "create_file",
+			file:    "../../escape",
+			content: testContent,
+			wantErr: true,
+			errMsg:  "paths are not allowed",
 		},
 	}
 
-	ctx := context.Background()
+	ctx := testChatContext()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -91,7 +127,8 @@ func TestFileOperations(t *testing.T) {
 
 			// Setup for read tests
 			if tt.op == "read_file" && !tt.wantErr {
-				setupFile := filepath.Join(testDir, tt.file)
+				os.MkdirAll(testChatDir, os.ModePerm)
+				setupFile := filepath.Join(testChatDir, tt.file)
 				err := os.WriteFile(setupFile, []byte(tt.content), 0644)
 				if err != nil {
 					t.Fatalf("failed to setup test file: %v", err)
@@ -109,12 +146,12 @@ func TestFileOperations(t *testing.T) {
 				funCall.Args["file_content"] = tt.content
 			}
 
-			toolFunc, err := getTool(funCall.Name)
+			tool, err := getTool(funCall.Name)
 			if err != nil {
 				t.Fatalf("getTool error: %v", err)
 			}
 
-			result, err := toolFunc(ctx, funCall)
+			result, err := tool.Execute(ctx, funCall.Args)
 
 			// Error checking
 			if tt.wantErr {
@@ -139,7 +176,7 @@ func TestFileOperations(t *testing.T) {
 				}
 
 				// Verify file content
-				content, err := os.ReadFile(filepath.Join(testDir, tt.file+".txt"))
+				content, err := os.ReadFile(filepath.Join(testChatDir, tt.file+".txt"))
 				if err != nil {
 					t.Fatalf("failed to read created file: %v", err)
 				}
@@ -156,7 +193,7 @@ func TestFileOperations(t *testing.T) {
 }
 
 func TestCreateAndReadSequence(t *testing.T) {
-	ctx := context.Background()
+	ctx := testChatContext()
 
 	// Clean start
 	os.RemoveAll(testDir)
@@ -172,7 +209,7 @@ func TestCreateAndReadSequence(t *testing.T) {
 	}
 
 	createTool, _ := getTool(createCall.Name)
-	result, err := createTool(ctx, createCall)
+	result, err := createTool.Execute(ctx, createCall.Args)
 	if err != nil {
 		t.Fatalf("create failed: %v", err)
 	}
@@ -189,7 +226,7 @@ func TestCreateAndReadSequence(t *testing.T) {
 	}
 
 	readTool, _ := getTool(readCall.Name)
-	content, err := readTool(ctx, readCall)
+	content, err := readTool.Execute(ctx, readCall.Args)
 	if err != nil {
 		t.Fatalf("read failed: %v", err)
 	}
@@ -199,6 +236,256 @@ func TestCreateAndReadSequence(t *testing.T) {
 	}
 }
 
+func TestReadFileIsolatedPerChat(t *testing.T) {
+	os.RemoveAll(testDir)
+	os.MkdirAll(testDir, os.ModePerm)
+
+	createCall := genai.FunctionCall{
+		Name: "create_file",
+		Args: map[string]any{
+			"file_name":    testFile,
+			"file_content": testContent,
+		},
+	}
+	if _, err := callTool(testChatContext(), createCall); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	readCall := genai.FunctionCall{
+		Name: "read_file",
+		Args: map[string]any{"file_name": testFileExt},
+	}
+
+	otherChat := context.WithValue(context.Background(), "chatId", 7)
+	if _, err := callTool(otherChat, readCall); err == nil {
+		t.Error("expected other chat to be unable to read the file")
+	}
+
+	if _, err := callTool(context.Background(), readCall); err == nil {
+		t.Error("expected read without a chat to fail")
+	}
+}
+
+func TestReadFileSymlink(t *testing.T) {
+	os.RemoveAll(testDir)
+	os.MkdirAll(testChatDir, os.ModePerm)
+
+	target, err := filepath.Abs("file_test.go")
+	if err != nil {
+		t.Fatalf("failed to resolve target: %v", err)
+	}
+	if err := os.Symlink(target, filepath.Join(testChatDir, "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	readCall := genai.FunctionCall{
+		Name: "read_file",
+		Args: map[string]any{"file_name": "link.txt"},
+	}
+	if _, err := callTool(testChatContext(), readCall); err == nil {
+		t.Error("expected symlink to be rejected")
+	}
+}
+
+func TestReadFileFormats(t *testing.T) {
+	os.RemoveAll(testDir)
+	os.MkdirAll(testChatDir, os.ModePerm)
+
+	files := map[string]string{
+		"page.html":  "<html><head><style>p{}</style></head><body><h1>Title</h1><p>Body text</p></body></html>",
+		"data.json":  `{"a": 1}`,
+		"binary.exe": "MZ\x00\x00",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(testChatDir, name), []byte(content), 0644)
+	}
+
+	read := func(name string) (string, error) {
+		return callTool(testChatContext(), genai.FunctionCall{
+			Name: "read_file",
+			Args: map[string]any{"file_name": name},
+		})
+	}
+
+	html, err := read("page.html")
+	if err != nil {
+		t.Fatalf("html read failed: %v", err)
+	}
+	if html != "Title\nBody text" {
+		t.Errorf("html text = %q", html)
+	}
+
+	if json, err := read("data.json"); err != nil || json != files["data.json"] {
+		t.Errorf("json read = %q, %v", json, err)
+	}
+
+	if _, err := read("binary.exe"); err == nil || !strings.Contains(err.Error(), "unsupported file type") {
+		t.Errorf("expected unsupported file type error, got %v", err)
+	}
+}
+
+func TestCreateFileFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		ext      string
+		content  string
+		wantFile string
+		errMsg   string
+	}{
+		{
+			name:     "markdown",
+			file:     "notes",
+			ext:      "md",
+			content:  "# Notes",
+			wantFile: "notes.md",
+		},
+		{
+			name:     "extension in name",
+			file:     "data.json",
+			ext:      "json",
+			content:  `{"a": [1, 2]}`,
+			wantFile: "data.json",
+		},
+		{
+			name:    "invalid json",
+			file:    "data",
+			ext:     "json",
+			content: `{"a": }`,
+			errMsg:  "invalid json content",
+		},
+		{
+			name:     "csv",
+			file:     "table",
+			ext:      "csv",
+			content:  "name,age\nharsh,22\n",
+			wantFile: "table.csv",
+		},
+		{
+			name:    "csv with inconsistent rows",
+			file:    "table",
+			ext:     "csv",
+			content: "name,age\nharsh\n",
+			errMsg:  "invalid csv content",
+		},
+		{
+			name:    "unsupported extension",
+			file:    "virus",
+			ext:     "exe",
+			content: "MZ",
+			errMsg:  `invalid file_extension argument: expected one of txt, md`,
+		},
+		{
+			name:    "extension not as declared",
+			file:    "data",
+			ext:     ".JSON",
+			content: `{}`,
+			errMsg:  `got ".JSON"`,
+		},
+		{
+			name:     "source code",
+			file:     "main",
+			ext:      "go",
+			content:  "package main",
+			wantFile: "main.go",
+		},
+	}
+
+	ctx := testChatContext()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(testDir)
+
+			_, err := callTool(ctx, genai.FunctionCall{
+				Name: "create_file",
+				Args: map[string]any{
+					"file_name":      tt.file,
+					"file_content":   tt.content,
+					"file_extension": tt.ext,
+				},
+			})
+
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(testChatDir, tt.wantFile)); err != nil {
+				t.Errorf("expected %s to be created: %v", tt.wantFile, err)
+			}
+		})
+	}
+}
+
+func TestCreatePDFFile(t *testing.T) {
+	os.RemoveAll(testDir)
+
+	content := "# Rust Book\n\nOwnership is Rust's most unique feature.\n\n- borrowing\n- lifetimes\n\n```\nfn main() {}\n```\n" +
+		strings.Repeat("A long paragraph that has to be wrapped over several lines and pages. ", 400)
+
+	_, err := callTool(testChatContext(), genai.FunctionCall{
+		Name: "create_file",
+		Args: map[string]any{
+			"file_name":      "rust_book",
+			"file_content":   content,
+			"file_extension": "pdf",
+		},
+	})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(testChatDir, "rust_book.pdf"))
+	if err != nil {
+		t.Fatalf("failed to read pdf: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "%PDF-") {
+		t.Fatalf("not a pdf: %q", data[:10])
+	}
+
+	text, err := pdfText(data)
+	if err != nil {
+		t.Fatalf("failed to extract pdf text: %v", err)
+	}
+	for _, want := range []string{"Rust Book", "Ownership", "borrowing", "fn main()"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("pdf text missing %q", want)
+		}
+	}
+}
+
+func TestCreatePDFFileUnsupportedCharacters(t *testing.T) {
+	os.RemoveAll(testDir)
+
+	for _, content := range []string{"Привет мир", "नमस्ते", "你好", "Done 🎉"} {
+		_, err := callTool(testChatContext(), genai.FunctionCall{
+			Name: "create_file",
+			Args: map[string]any{
+				"file_name":      "greeting",
+				"file_content":   content,
+				"file_extension": "pdf",
+			},
+		})
+		if err == nil || !strings.Contains(err.Error(), "md or txt") {
+			t.Errorf("%q: err = %v, want it to suggest another format", content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(testChatDir, "greeting.pdf")); err == nil {
+		t.Error("a broken pdf was written")
+	}
+
+	// Latin-1 and the WinAnsi punctuation are fine
+	if _, err := fileData("pdf", "Café “naïve” — 5 €\tdone"); err != nil {
+		t.Errorf("WinAnsi content rejected: %v", err)
+	}
+}
+
 func TestCleanupService(t *testing.T) {
 	testDir := "test_synapse_files"
 	if err := os.MkdirAll(testDir, os.ModePerm); err != nil {
@@ -291,6 +578,35 @@ func TestCleanupServiceEdgeCases(t *testing.T) {
 				cleanup.Stop()
 			},
 		},
+		{
+			name: "per chat directories",
+			test: func(t *testing.T) {
+				dir := "chat_test_dir"
+				defer os.RemoveAll(dir)
+
+				oldDir := filepath.Join(dir, "1")
+				newDir := filepath.Join(dir, "2")
+				os.MkdirAll(oldDir, os.ModePerm)
+				os.MkdirAll(newDir, os.ModePerm)
+
+				old := time.Now().Add(-2 * time.Hour)
+				os.WriteFile(filepath.Join(oldDir, "old.txt"), []byte("x"), 0644)
+				os.Chtimes(filepath.Join(oldDir, "old.txt"), old, old)
+				os.WriteFile(filepath.Join(newDir, "new.txt"), []byte("x"), 0644)
+
+				cleanup := NewCleanupService(dir)
+				if err := cleanup.CleanupNow(); err != nil {
+					t.Fatalf("cleanup failed: %v", err)
+				}
+
+				if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+					t.Errorf("expected empty chat directory to be removed")
+				}
+				if _, err := os.Stat(filepath.Join(newDir, "new.txt")); err != nil {
+					t.Errorf("expected new file to be kept: %v", err)
+				}
+			},
+		},
 		{
 			name: "multiple start/stop",
 			test: func(t *testing.T) {
@@ -2,27 +2,65 @@ package genai
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/generative-ai-go/genai"
-	"google.golang.org/api/option"
 )
 
 type Handler struct {
 	bot             TelegramBot
+	models          ModelFactory
 	processingState map[int]*ProcessingState
 	stateMutex      sync.RWMutex
+	// last run handed out, shared by all chats so a state that is deleted
+	// and created again never reuses the run of a goroutine still around
+	lastRun   int
+	streaming bool
+	// counts history tokens, the model's counter when enabled
+	tokenCounter TokenCounter
+	// summarize trimmed history instead of discarding it
+	summarizing bool
+	// runs the queues the watchdog takes over, see SetWorkerPool
+	pool *WorkerPool
 }
 
 type ProcessingState struct {
-	IsProcessing    bool
+	IsProcessing bool
+	// StartTime is when the current message started, the watchdog gives up
+	// on it after TimeoutDuration
 	StartTime       time.Time
 	TimeoutDuration time.Duration
+	// Cancel stops the message being processed, set while IsProcessing
+	Cancel context.CancelCauseFunc
+	// Queue holds the messages waiting for the current one, oldest first
+	Queue []queuedMessage
+
+	// run identifies each acquisition of the chat so a goroutine the
+	// watchdog gave up on can't touch the state of the one that replaced it
+	run int
+	// loading message of the current message
+	messageID int
+}
+
+var (
+	// errCanceledByUser is the cause of a request's context when the user
+	// sent /cancel.
+	errCanceledByUser = errors.New("canceled by the user")
+	// errProcessingStuck is the cause when the watchdog gave up on a request.
+	errProcessingStuck = errors.New("processing got stuck")
+)
+
+// isCalledOff reports whether the request of ctx was cancelled by the user or
+// the watchdog, as opposed to running out of time.
+func isCalledOff(ctx context.Context) bool {
+	cause := context.Cause(ctx)
+	return errors.Is(cause, errCanceledByUser) || errors.Is(cause, errProcessingStuck)
 }
 
 type TelegramBot interface {
@@ -38,157 +76,269 @@ type MessageWithID struct {
 	MessageID int
 }
 
-func NewHandler(bot TelegramBot) *Handler {
-	return &Handler{
+// NewHandler creates a handler answering with Gemini configured by cfg, the
+// client is created once and shared by every request.
+func NewHandler(bot TelegramBot, apiKey string, cfg Config) (*Handler, error) {
+	models, err := NewGeminiModels(context.Background(), apiKey, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating Gemini client: %w", err)
+	}
+	return NewHandlerWithModels(bot, models), nil
+}
+
+// NewHandlerWithModels creates a handler answering with the models of
+// models.
+func NewHandlerWithModels(bot TelegramBot, models ModelFactory) *Handler {
+	h := &Handler{
 		bot:             bot,
+		models:          models,
 		stateMutex:      sync.RWMutex{},
 		processingState: make(map[int]*ProcessingState),
 	}
+	go h.startCleanupRoutine()
+	return h
 }
 
-func (h *Handler) tryAcquireProcessing(chatId int) bool {
-	h.stateMutex.Lock()
-	defer h.stateMutex.Unlock()
+// Close releases the models' client, if they have one.
+func (h *Handler) Close() error {
+	if closer, ok := h.models.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
 
-	state, exists := h.processingState[chatId]
-	if !exists {
-		log.Printf("Creating new state for chat %d", chatId)
+// SetWorkerPool makes the handler run the queued messages of chats the
+// watchdog gave up on in pool, the pool the messages are answered in.
+// Without one they get a goroutine of their own.
+func (h *Handler) SetWorkerPool(pool *WorkerPool) {
+	h.pool = pool
+}
 
-		state = &ProcessingState{
-			TimeoutDuration: 2 * time.Minute,
-			IsProcessing:    false,
-		}
-		h.processingState[chatId] = state
-	}
+// EnableStreaming makes the handler stream responses into the loading
+// message as they are generated instead of waiting for the full response.
+func (h *Handler) EnableStreaming() {
+	h.streaming = true
+}
 
-	if state.IsProcessing {
-		// if time.Since(state.StartTime) > state.TimeoutDuration {
-		// 	state.IsProcessing = false
-		// 	log.Printf("Chat %d timed out, allowing new processing", chatId)
-		// 	// goto DONE
-		// } else {
-		log.Printf("Chat %d is busy", chatId)
-		return false
-		// }
-	}
+// EnableModelTokenCounting measures history with the model's CountTokens
+// instead of the local estimate when trimming it to the token budget.
+func (h *Handler) EnableModelTokenCounting() {
+	h.tokenCounter = &ModelTokenCounter{Model: h.models.PlainModel()}
+}
 
-	// DONE:
-	log.Printf("Starting processing for chat %d", chatId)
-	state.IsProcessing = true
-	state.StartTime = time.Now()
-	return true
+// EnableSummarization keeps a running summary of the history entries that
+// are trimmed, so long chats remember what was said early on.
+func (h *Handler) EnableSummarization() {
+	h.summarizing = true
 }
 
-func (h *Handler) releaseProcessing(chatId int) {
-	h.stateMutex.Lock()
-	defer h.stateMutex.Unlock()
+// ProcessMessage answers a message, or queues it while an earlier message of
+// the chat is being answered. The queue is worked through by the goroutine
+// that got the chat, so ProcessMessage only returns once it is empty.
+func (h *Handler) ProcessMessage(userMessage string, chatID int, messageId int, attachments ...Attachment) {
+	msg := queuedMessage{
+		text:        userMessage,
+		messageIDs:  []int{messageId},
+		attachments: attachments,
+		queuedAt:    time.Now(),
+	}
 
-	if state, exists := h.processingState[chatId]; exists {
-		state.IsProcessing = false
+	run, position, err := h.tryAcquireProcessing(chatID, msg)
+	if err != nil {
+		h.bot.HandleUpdateMessage(chatID, messageId, "Too many messages are waiting, please send this one again once the previous ones are answered.")
+		return
+	}
+	if position > 0 {
+		h.bot.HandleUpdateMessage(chatID, messageId, queuedText(position))
+		return
 	}
 
+	h.workQueue(chatID, run, msg)
 }
 
-func (h *Handler) startCleanupRoutine() {
-	ticker := time.NewTicker(time.Minute * 5)
-	for range ticker.C {
-		h.cleanup()
-	}
-}
+// workQueue answers msg and then the chat's queued messages until the queue
+// is empty or the watchdog gives up on run.
+func (h *Handler) workQueue(chatID int, run int, msg queuedMessage) {
+	for {
+		for _, id := range msg.messageIDs[1:] {
+			h.bot.HandleUpdateMessage(chatID, id, "⏳Answering together with your previous message...")
+		}
 
-func (h *Handler) cleanup() {
-	h.stateMutex.Lock()
-	defer h.stateMutex.Unlock()
+		h.processTurn(chatID, run, msg)
 
-	for chatId, state := range h.processingState {
-		if time.Since(state.StartTime) > state.TimeoutDuration*2 {
-			delete(h.processingState, chatId)
+		next, waiting, ok := h.nextMessage(chatID, run)
+		if !ok {
+			return
+		}
+		for i, queued := range waiting {
+			h.bot.HandleUpdateMessage(chatID, queued.messageIDs[0], queuedText(i+1))
 		}
+		msg = next
 	}
 }
 
-func (h *Handler) ProcessMessage(userMessage string, chatID int, messageId int) {
-	if !h.tryAcquireProcessing(chatID) {
-		h.bot.HandleUpdateMessage(chatID, messageId, "Please wait, processing previous request...")
+// processTurn answers a single message, which may be several merged ones.
+func (h *Handler) processTurn(chatID int, run int, msg queuedMessage) {
+	userMessage, messageId, attachments := msg.text, msg.messageIDs[0], msg.attachments
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
+
+	if !h.startTurn(chatID, run, messageId, cancelCause) {
 		return
 	}
-	defer h.releaseProcessing(chatID)
 
 	defer func() {
 		if r := recover(); r != nil {
-			h.releaseProcessing(chatID)
 			log.Printf("Recovered from panic in ProcessMessage: %v", r)
 			h.bot.HandleUpdateMessage(chatID, messageId, "An error occurred, please try again")
 		}
 	}()
 
-	ctx := context.Background()
 	ctx = context.WithValue(ctx, "chatId", chatID)
 
 	h.bot.HandleUpdateMessage(chatID, messageId, "⏳Processing your request...")
 
-	client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("GEMINI_API_KEY")))
-	if err != nil {
-		log.Fatalf("Failed to initialize Gemini client: %v", err)
-	}
-	defer client.Close()
-
-	model := client.GenerativeModel("gemini-2.0-flash-exp")
-	model.SystemInstruction = genai.NewUserContent(genai.Text(InitialSystemPrompt))
-	model.Tools = []*genai.Tool{tools}
-	model.SafetySettings = []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategorySexuallyExplicit,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategoryHateSpeech,
-			Threshold: genai.HarmBlockNone,
-		},
-		{
-			Category:  genai.HarmCategoryDangerousContent,
-			Threshold: genai.HarmBlockNone,
-		},
-	}
+	model := h.models.ChatModel()
 
-	chatHistory := getOrCreateChatHistory(chatID)
+	var counter TokenCounter = estimateTokenCounter{}
+	if h.tokenCounter != nil {
+		counter = h.tokenCounter
+	}
 
-	cs := model.StartChat()
+	var summarizer Summarizer
+	if h.summarizing {
+		summarizer = ModelSummarizer{Model: h.models.PlainModel()}
+	}
 
-	chatHistory.AddMessage("user", genai.Text(userMessage))
+	if err := trimChatHistory(ctx, chatID, counter, summarizer); err != nil {
+		log.Println("Error trimming history:", err)
+	}
 
-	// Last n (15) messages for context
-	lastMessages, err := chatHistory.GetLastMessages()
+	// History that fits in the token budget, the new message is sent separately
+	lastMessages, err := getLastMessages(chatID)
 	if err != nil {
 		log.Println("Error getting last messages:", err)
 	}
 
-	cs.History = lastMessages
+	cs := model.StartChat(lastMessages)
+
+	var parts []genai.Part
+	if userMessage != "" {
+		parts = append(parts, genai.Text(userMessage))
+	}
+	// history gets notes instead of the files, they'd be sent again with
+	// every later message otherwise
+	recorded := append([]genai.Part(nil), parts...)
+	files, notes := attachmentParts(attachments)
+	parts = append(parts, files...)
+	recorded = append(recorded, notes...)
+
+	for _, a := range attachments {
+		if !a.Document {
+			continue
+		}
+
+		name, err := saveUpload(ctx, a.FileName, a.Data)
+		if err != nil {
+			logWithTime("Error saving upload for chat %d: %v", chatID, err)
+			continue
+		}
+		note := genai.Text(uploadNote(name, time.Now()))
+		parts = append(parts, note)
+		recorded = append(recorded, note)
+	}
+
+	t := &turn{
+		ctx:       ctx,
+		cs:        cs,
+		bot:       h.bot,
+		chatID:    chatID,
+		messageID: messageId,
+		streaming: h.streaming,
+	}
+
+	t.record("user", recorded...)
 
-	res, err := cs.SendMessage(ctx, genai.Text(userMessage))
+	res, err := t.send(parts...)
+	if err == nil {
+		runAgentLoop(t, res)
+	}
+
+	// a cancelled turn is left out of the history, as if it was never sent
+	if t.canceled() {
+		// the watchdog has already told the user about a stuck request
+		if errors.Is(context.Cause(ctx), errCanceledByUser) {
+			logWithTime("Chat %d cancelled the request", chatID)
+			h.bot.HandleUpdateMessage(chatID, t.messageID, "Cancelled")
+		}
+		return
+	}
+	t.saveHistory()
 
 	if err != nil {
 		logWithTime("Error sending message: %v", err)
-		h.bot.HandleUpdateMessage(chatID, messageId, "something went wrong!, please try again after sometime.")
+		h.bot.HandleUpdateMessage(chatID, t.messageID, "something went wrong!, please try again after sometime.")
+	}
+}
+
+// turn holds the state of answering a single user message. messageID is the
+// message currently used for output, it moves forward when a streamed
+// response rolls over into a new message.
+type turn struct {
+	ctx       context.Context
+	cs        ChatSession
+	bot       TelegramBot
+	chatID    int
+	messageID int
+	streaming bool
+	// history entries of the turn, saved once it is over
+	history []Conversation
+}
+
+// record adds an entry to the turn's history.
+func (t *turn) record(role string, parts ...genai.Part) {
+	t.history = append(t.history, Conversation{Role: role, Parts: parts})
+}
+
+// saveHistory appends the turn's entries to the chat's history.
+func (t *turn) saveHistory() {
+	if len(t.history) == 0 {
 		return
 	}
+	if err := historyStore.Append(t.chatID, t.history...); err != nil {
+		logWithTime("Error saving history for chat %d: %v", t.chatID, err)
+	}
+	t.history = nil
+}
 
-	handleResponse(ctx, cs, h.bot, res, chatID, messageId, func() {
-		h.releaseProcessing(chatID)
-	})
+// canceled reports whether the turn was called off, with /cancel or by the
+// watchdog.
+func (t *turn) canceled() bool {
+	return isCalledOff(t.ctx)
 }
 
-func handleResponse(ctx context.Context, cs *genai.ChatSession, bot TelegramBot, resp *genai.GenerateContentResponse, chatId int, messageId int, onComplete func()) {
-	defer onComplete()
+func (t *turn) send(parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	if t.streaming {
+		return t.sendStream(parts...)
+	}
+	return t.cs.SendMessage(t.ctx, parts...)
+}
 
+// renderResponse shows the text of resp to the user and returns its
+// function calls, which are answered together in a single message.
+func renderResponse(t *turn, resp *genai.GenerateContentResponse) []genai.FunctionCall {
 	if resp == nil {
-		return
+		return nil
 	}
 
+	chatId := t.chatID
+	bot := t.bot
+
+	var calls []genai.FunctionCall
+
 	for _, cand := range resp.Candidates {
 		if cand.Content == nil {
 			continue
@@ -199,119 +349,74 @@ func handleResponse(ctx context.Context, cs *genai.ChatSession, bot TelegramBot,
 			case genai.Text:
 				if text := strings.TrimSpace(string(v)); text != "" {
 					fmt.Printf("1. Gemini: %s\n", text)
-					history := getOrCreateChatHistory(chatId)
-					history.AddMessage("model", v)
-
-					if len(text) < 4096 {
-						log.Println("Character length under 4096")
-						bot.HandleUpdateMessage(chatId, messageId, text)
-					} else {
-						log.Println("Character length above 4096")
-						chunks := splitMessage(text, 4096)
-						if len(chunks) > 0 {
-							bot.HandleUpdateMessage(chatId, messageId, chunks[0])
-						}
+					t.record("model", v)
 
-						for i := 1; i < len(chunks); i++ {
-							if err := bot.HandleSendMessage(chatId, chunks[i]); err != nil {
-								log.Printf("Error sending message chunk: %v", err)
-							}
-						}
+					// already rendered while streaming
+					if t.streaming {
+						continue
 					}
 
-				}
-
-			case genai.FunctionCall:
-				history := getOrCreateChatHistory(chatId)
-
-				toolFunc, err := getTool(v.Name)
-				if err != nil {
-					logWithTime("Error retrieving tool: %v\n", err)
-					sendToolError(ctx, cs, bot, v.Name, fmt.Sprintf("Tool '%s' not found.", v.Name), chatId, messageId, onComplete)
-					continue
-				}
-
-				history.AddFunctionCall(&v)
-
-				bot.HandleUpdateMessage(chatId, messageId, fmt.Sprintf("Executing %s", v.Name))
-
-				toolStartTime := time.Now()
-				result, err := toolFunc(ctx, v)
-				if err != nil {
-					logWithTime("Error executing tool '%s': %v\n", v.Name, err)
-					sendToolError(ctx, cs, bot, v.Name, err.Error(), chatId, messageId, onComplete)
-					continue
-				}
-
-				logWithTime("%s Function executed successfully", v.Name)
-				toolExecutionTime := time.Since(toolStartTime).Round(time.Millisecond)
-				bot.HandleUpdateMessage(chatId, messageId, fmt.Sprintf("%s execution completed in %v. Processing results...", v.Name, toolExecutionTime))
-
-				// WARN: update it...
-				if strings.HasPrefix(result, "File created successfully at") {
-					filePath := strings.TrimPrefix(result, "File created successfully at ")
-					err = bot.SendFileWithProgress(chatId, filePath)
-					if err != nil {
-						logWithTime("Error sending file: %v\n", err)
+					// the first chunk replaces the loading message, the
+					// rest of a long answer follows in new messages
+					chunk, rest := splitChunk(text, maxMessageLength)
+					bot.HandleUpdateMessage(chatId, t.messageID, chunk)
+					for rest != "" {
+						chunk, rest = splitChunk(rest, maxMessageLength)
+						if err := bot.HandleSendMessage(chatId, chunk); err != nil {
+							log.Printf("Error sending message chunk: %v", err)
+						}
 					}
 				}
 
-				geminiStartTime := time.Now()
-
-				nextResp, err := cs.SendMessage(ctx, genai.FunctionResponse{
-					Name:     v.Name,
-					Response: map[string]any{"function response: ": result},
-				})
-
-				geminiProcessingTime := time.Since(geminiStartTime).Round(time.Millisecond)
-
-				logWithTime("Gemini processing completed in %v", geminiProcessingTime)
-
-				history.AddFunctionResponse(&genai.FunctionResponse{
-					Name:     v.Name,
-					Response: map[string]any{"function response: ": result},
-				})
-
-				if err != nil {
-					logWithTime("Error sending function response first response's nextResp: %v", err)
-					continue
-				}
-
-				if hasNonEmptyContent(nextResp) {
-					handleResponse(ctx, cs, bot, nextResp, chatId, messageId, onComplete)
-				}
+			case genai.FunctionCall:
+				calls = append(calls, v)
 
 			default:
 				fmt.Printf("Gemini: (Non-textual response) %v\n", part)
 			}
 		}
 	}
+
+	return calls
 }
 
-func sendToolError(ctx context.Context, cs *genai.ChatSession, bot TelegramBot, toolName, errorMsg string, chatId int, messageId int, onComplete func()) {
-	resp, err := cs.SendMessage(ctx, genai.FunctionResponse{
-		Name: toolName,
-		Response: map[string]any{
-			"error": errorMsg,
-		},
-	})
+// handleFunctionCalls runs the calls of a turn concurrently and returns
+// their responses in the order of the calls.
+func handleFunctionCalls(t *turn, calls []genai.FunctionCall) []genai.Part {
+	chatId := t.chatID
+	bot := t.bot
+
+	callParts := make([]genai.Part, len(calls))
+	names := make([]string, len(calls))
+	for i, call := range calls {
+		callParts[i] = call
+		names[i] = call.Name
+	}
+	// recorded before running them so every response has its call
+	t.record("model", callParts...)
 
-	bot.HandleUpdateMessage(chatId, messageId, errorMsg)
-	history := getOrCreateChatHistory(chatId)
+	bot.HandleUpdateMessage(chatId, t.messageID, fmt.Sprintf("Executing %s", strings.Join(names, ", ")))
 
-	history.AddFunctionResponse(&genai.FunctionResponse{
-		Name: toolName,
-		Response: map[string]any{
-			"error": errorMsg,
-		},
-	})
+	toolStartTime := time.Now()
+	results := executeToolCalls(t.ctx, calls)
+	toolExecutionTime := time.Since(toolStartTime).Round(time.Millisecond)
 
-	if err != nil {
-		logWithTime("Error sending error response: %v", err)
-		return
+	responses := make([]genai.Part, len(results))
+	for i, r := range results {
+		responses[i] = r.response()
+
+		// WARN: update it...
+		if r.err == nil && strings.HasPrefix(r.result, "File created successfully at") {
+			filePath := strings.TrimPrefix(r.result, "File created successfully at ")
+			if err := bot.SendFileWithProgress(chatId, filePath); err != nil {
+				logWithTime("Error sending file: %v\n", err)
+			}
+		}
 	}
 
-	handleResponse(ctx, cs, bot, resp, chatId, messageId, onComplete)
+	bot.HandleUpdateMessage(chatId, t.messageID, fmt.Sprintf("%s execution completed in %v. Processing results...", strings.Join(names, ", "), toolExecutionTime))
+
+	return responses
 }
 
 // Print the response
@@ -325,29 +430,6 @@ func printResponse(resp *genai.GenerateContentResponse) {
 	}
 }
 
-func splitMessage(text string, maxLength int) []string {
-	var chunks []string
-
-	if len(text) > maxLength {
-		chunk := text[:maxLength]
-
-		lastSpace := strings.LastIndex(chunk, " ")
-
-		if lastSpace == -1 {
-			chunks = append(chunks, chunk)
-			text = text[maxLength:]
-		} else {
-			chunks = append(chunks, text[:lastSpace])
-			text = text[maxLength+1:]
-		}
-	}
-
-	if len(chunks) > 0 {
-		chunks = append(chunks, text)
-	}
-	return chunks
-}
-
 func hasNonEmptyContent(resp *genai.GenerateContentResponse) bool {
 	if resp == nil {
 		return false
@@ -0,0 +1,141 @@
+package genai
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// fakeModel starts fakeChats answering with reply and remembers the history
+// each chat started from.
+type fakeModel struct {
+	reply     func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse
+	histories [][]*genai.Content
+	chats     []*fakeChat
+}
+
+func (m *fakeModel) StartChat(history []*genai.Content) ChatSession {
+	m.histories = append(m.histories, history)
+	chat := &fakeChat{reply: m.reply}
+	m.chats = append(m.chats, chat)
+	return chat
+}
+
+func (m *fakeModel) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	return m.reply(ctx, parts), nil
+}
+
+func (m *fakeModel) CountTokens(ctx context.Context, parts ...genai.Part) (*genai.CountTokensResponse, error) {
+	return &genai.CountTokensResponse{TotalTokens: int32(len(parts))}, nil
+}
+
+type fakeModels struct {
+	chat *fakeModel
+}
+
+func (f *fakeModels) ChatModel() Model {
+	return f.chat
+}
+
+func (f *fakeModels) PlainModel() Model {
+	return f.chat
+}
+
+func TestProcessMessage(t *testing.T) {
+	const chatID = 9201
+	historyStore.Delete(chatID)
+	defer historyStore.Delete(chatID)
+
+	model := &fakeModel{
+		reply: func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+			return modelResponse(genai.Text("Hello there"))
+		},
+	}
+	bot := &fakeBot{}
+	h := NewHandlerWithModels(bot, &fakeModels{chat: model})
+
+	h.ProcessMessage("hi", chatID, 1)
+	if bot.last() != "Hello there" {
+		t.Errorf("last message = %q", bot.last())
+	}
+
+	// the same model continues the chat from the saved history
+	h.ProcessMessage("and again", chatID, 2)
+	if len(model.histories) != 2 {
+		t.Fatalf("started %d chats, want 2", len(model.histories))
+	}
+	history := model.histories[1]
+	if len(history) != 2 || history[0].Role != "user" || history[1].Role != "model" {
+		t.Errorf("second chat started from %d entries", len(history))
+	}
+}
+
+func TestProcessMessageKeepsFilesOutOfHistory(t *testing.T) {
+	const chatID = 9202
+	historyStore.Delete(chatID)
+	defer historyStore.Delete(chatID)
+
+	model := &fakeModel{
+		reply: func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+			return modelResponse(genai.Text("A cat"))
+		},
+	}
+	h := NewHandlerWithModels(&fakeBot{}, &fakeModels{chat: model})
+
+	photo := Attachment{FileName: "photo.jpg", MIMEType: "image/jpeg", Data: []byte("jpeg data")}
+	h.ProcessMessage("what is this?", chatID, 1, photo)
+
+	sent := model.chats[0].sent[0]
+	if _, ok := sent[1].(genai.Blob); !ok {
+		t.Fatalf("photo sent as %T, want a blob", sent[1])
+	}
+
+	history, err := historyStore.Load(chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range history[0].Parts {
+		if _, ok := part.(genai.Blob); ok {
+			t.Fatal("photo saved in history")
+		}
+	}
+	if note, _ := history[0].Parts[1].(genai.Text); note != "[attached image/jpeg photo.jpg]" {
+		t.Errorf("history note = %q", note)
+	}
+}
+
+func TestProcessMessageNotesUploadExpiry(t *testing.T) {
+	const chatID = 9203
+	historyStore.Delete(chatID)
+	defer historyStore.Delete(chatID)
+	dir, _ := chatFileDir(chatID)
+	defer os.RemoveAll(dir)
+
+	model := &fakeModel{
+		reply: func(ctx context.Context, parts []genai.Part) *genai.GenerateContentResponse {
+			return modelResponse(genai.Text("Got it"))
+		},
+	}
+	h := NewHandlerWithModels(&fakeBot{}, &fakeModels{chat: model})
+
+	doc := Attachment{FileName: "data.bin", MIMEType: "application/octet-stream", Data: []byte{1, 2}, Document: true}
+	start := time.Now()
+	h.ProcessMessage("keep this", chatID, 1, doc)
+
+	history, err := historyStore.Load(chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note, _ := history[0].Parts[len(history[0].Parts)-1].(genai.Text)
+	expires := start.Add(fileLifetime).UTC().Format("15:04 UTC")
+	// the minute may have turned while the message was processed
+	later := time.Now().Add(fileLifetime).UTC().Format("15:04 UTC")
+	if !strings.Contains(string(note), `"data.bin"`) ||
+		!strings.Contains(string(note), "until about "+expires) && !strings.Contains(string(note), "until about "+later) {
+		t.Errorf("upload note = %q, want it to say the file expires at %s", note, expires)
+	}
+}
@@ -1,6 +1,7 @@
 package genai
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -16,70 +17,166 @@ type Conversation struct {
 type ChatHistory struct {
 	ChatID     int       `json:"chat_id"`
 	TimeStamps time.Time `json:"time_stamps"`
+	// Summary of the entries that were trimmed from History
+	Summary string `json:"summary,omitempty"`
 
 	mu      sync.Mutex
 	History []Conversation `json:"history"`
 }
 
-var (
-	chatHistories  sync.Map
-	maxHistorySize = 15
-)
+// HistoryStore keeps the conversation of every chat. Implementations must be
+// safe for concurrent use.
+type HistoryStore interface {
+	// Load returns the stored conversation of a chat, oldest first.
+	Load(chatID int) ([]Conversation, error)
+	Append(chatID int, entries ...Conversation) error
+	// Trim drops the oldest entries so that at most keep entries are left.
+	Trim(chatID int, keep int) error
+	Delete(chatID int) error
 
-func (ch *ChatHistory) trimHistory() {
-	if len(ch.History) > maxHistorySize {
-		newHistory := make([]Conversation, maxHistorySize)
-		copy(newHistory, ch.History[len(ch.History)-maxHistorySize:])
-		ch.History = newHistory
-	}
+	// LoadSummary returns the summary of the trimmed entries, if any.
+	LoadSummary(chatID int) (string, error)
+	SaveSummary(chatID int, summary string) error
 }
 
-func (ch *ChatHistory) AddMessage(role string, parts ...genai.Part) {
+var historyStore HistoryStore = NewMemoryHistoryStore()
+
+// SetHistoryStore replaces the store used for every chat's history, it should
+// be called before the first message is processed.
+func SetHistoryStore(store HistoryStore) {
+	historyStore = store
+}
+
+func (ch *ChatHistory) append(entries ...Conversation) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	ch.History = append(ch.History, Conversation{
-		Role:  role,
-		Parts: parts,
-	})
-
-	ch.trimHistory()
+	ch.History = append(ch.History, entries...)
+	ch.TimeStamps = time.Now()
 }
 
-func (ch *ChatHistory) AddFunctionCall(call *genai.FunctionCall) {
+func (ch *ChatHistory) trim(keep int) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	ch.History = append(ch.History, Conversation{
-		Role:  "model",
-		Parts: []genai.Part{call},
-	})
+	if keep < 0 {
+		keep = 0
+	}
 
-	ch.trimHistory()
+	if len(ch.History) > keep {
+		newHistory := make([]Conversation, keep)
+		copy(newHistory, ch.History[len(ch.History)-keep:])
+		ch.History = newHistory
+	}
 }
 
-func (ch *ChatHistory) AddFunctionResponse(response *genai.FunctionResponse) {
+func (ch *ChatHistory) summary() string {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	ch.History = append(ch.History, Conversation{
-		Role:  "function",
-		Parts: []genai.Part{response},
-	})
+	return ch.Summary
+}
+
+func (ch *ChatHistory) setSummary(summary string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
 
-	ch.trimHistory()
+	ch.Summary = summary
 }
 
-func (ch *ChatHistory) GetLastMessages() ([]*genai.Content, error) {
+func (ch *ChatHistory) entries() []Conversation {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	if len(ch.History) == 0 {
+	entries := make([]Conversation, len(ch.History))
+	copy(entries, ch.History)
+	return entries
+}
+
+// MemoryHistoryStore keeps histories in process memory, they are lost on
+// restart.
+type MemoryHistoryStore struct {
+	chatHistories sync.Map
+}
+
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{}
+}
+
+func (s *MemoryHistoryStore) getOrCreate(chatID int) *ChatHistory {
+	if history, ok := s.chatHistories.Load(chatID); ok {
+		return history.(*ChatHistory)
+	}
+
+	newHistory := &ChatHistory{
+		ChatID:     chatID,
+		History:    []Conversation{},
+		TimeStamps: time.Now(),
+	}
+
+	actual, _ := s.chatHistories.LoadOrStore(chatID, newHistory)
+	return actual.(*ChatHistory)
+}
+
+func (s *MemoryHistoryStore) Load(chatID int) ([]Conversation, error) {
+	history, ok := s.chatHistories.Load(chatID)
+	if !ok {
+		return nil, nil
+	}
+	return history.(*ChatHistory).entries(), nil
+}
+
+func (s *MemoryHistoryStore) Append(chatID int, entries ...Conversation) error {
+	s.getOrCreate(chatID).append(entries...)
+	return nil
+}
+
+func (s *MemoryHistoryStore) Trim(chatID int, keep int) error {
+	if history, ok := s.chatHistories.Load(chatID); ok {
+		history.(*ChatHistory).trim(keep)
+	}
+	return nil
+}
+
+func (s *MemoryHistoryStore) Delete(chatID int) error {
+	s.chatHistories.Delete(chatID)
+	return nil
+}
+
+func (s *MemoryHistoryStore) LoadSummary(chatID int) (string, error) {
+	history, ok := s.chatHistories.Load(chatID)
+	if !ok {
+		return "", nil
+	}
+	return history.(*ChatHistory).summary(), nil
+}
+
+func (s *MemoryHistoryStore) SaveSummary(chatID int, summary string) error {
+	s.getOrCreate(chatID).setSummary(summary)
+	return nil
+}
+
+func getLastMessages(chatID int) ([]*genai.Content, error) {
+	history, err := historyStore.Load(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := historyStore.LoadSummary(chatID)
+	if err != nil {
+		logWithTime("Error loading summary for chat %d: %v", chatID, err)
+	}
+
+	if summary != "" {
+		history = append(summaryConversation(summary), history...)
+	}
+
+	if len(history) == 0 {
 		return nil, fmt.Errorf("no message available")
 	}
 
-	messages := make([]*genai.Content, 0, len(ch.History))
-	for _, v := range ch.History {
+	messages := make([]*genai.Content, 0, len(history))
+	for _, v := range history {
 		messages = append(messages, &genai.Content{
 			Parts: v.Parts,
 			Role:  v.Role,
@@ -89,30 +186,87 @@ func (ch *ChatHistory) GetLastMessages() ([]*genai.Content, error) {
 	return messages, nil
 }
 
-func getOrCreateChatHistory(chatId int) *ChatHistory {
+// partJSON is the serialized form of a genai.Part, exactly one field is set.
+type partJSON struct {
+	Text             *string                 `json:"text,omitempty"`
+	FunctionCall     *genai.FunctionCall     `json:"function_call,omitempty"`
+	FunctionResponse *genai.FunctionResponse `json:"function_response,omitempty"`
+	Blob             *genai.Blob             `json:"blob,omitempty"`
+}
 
-	if history, ok := chatHistories.Load(chatId); ok {
-		historyWithTimeStapms := history.(*ChatHistory)
-		historyWithTimeStapms.mu.Lock()
-		historyWithTimeStapms.TimeStamps = time.Now()
-		historyWithTimeStapms.mu.Unlock()
-		return historyWithTimeStapms
+func marshalPart(part genai.Part) (partJSON, error) {
+	switch v := part.(type) {
+	case genai.Text:
+		text := string(v)
+		return partJSON{Text: &text}, nil
+	case genai.FunctionCall:
+		return partJSON{FunctionCall: &v}, nil
+	case *genai.FunctionCall:
+		return partJSON{FunctionCall: v}, nil
+	case genai.FunctionResponse:
+		return partJSON{FunctionResponse: &v}, nil
+	case *genai.FunctionResponse:
+		return partJSON{FunctionResponse: v}, nil
+	case genai.Blob:
+		return partJSON{Blob: &v}, nil
+	case *genai.Blob:
+		return partJSON{Blob: v}, nil
+	default:
+		return partJSON{}, fmt.Errorf("unsupported part type %T", part)
 	}
+}
 
-	newHistory := &ChatHistory{
-		ChatID:     chatId,
-		History:    []Conversation{},
-		TimeStamps: time.Now(),
+func (p partJSON) part() (genai.Part, error) {
+	switch {
+	case p.Text != nil:
+		return genai.Text(*p.Text), nil
+	case p.FunctionCall != nil:
+		return *p.FunctionCall, nil
+	case p.FunctionResponse != nil:
+		return *p.FunctionResponse, nil
+	case p.Blob != nil:
+		return *p.Blob, nil
+	default:
+		return nil, fmt.Errorf("empty part")
 	}
+}
 
-	actual, loaded := chatHistories.LoadOrStore(chatId, newHistory)
-	if loaded {
-		historyWithTimeStapms := actual.(*ChatHistory)
-		historyWithTimeStapms.mu.Lock()
-		historyWithTimeStapms.TimeStamps = time.Now()
-		historyWithTimeStapms.mu.Unlock()
-		return historyWithTimeStapms
+func (c Conversation) MarshalJSON() ([]byte, error) {
+	parts := make([]partJSON, 0, len(c.Parts))
+	for _, part := range c.Parts {
+		p, err := marshalPart(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
 	}
 
-	return newHistory
+	return json.Marshal(struct {
+		Role  string     `json:"role"`
+		Parts []partJSON `json:"parts"`
+	}{
+		Role:  c.Role,
+		Parts: parts,
+	})
+}
+
+func (c *Conversation) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role  string     `json:"role"`
+		Parts []partJSON `json:"parts"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	c.Role = raw.Role
+	c.Parts = make([]genai.Part, 0, len(raw.Parts))
+	for i, p := range raw.Parts {
+		part, err := p.part()
+		if err != nil {
+			return fmt.Errorf("part %d: %w", i, err)
+		}
+		c.Parts = append(c.Parts, part)
+	}
+	return nil
 }
@@ -0,0 +1,276 @@
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// TokenCounter tells how many prompt tokens a history entry costs.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, entry Conversation) (int, error)
+}
+
+var historyTokenBudget = 32000
+
+// SetHistoryTokenBudget sets how many tokens of history are sent with every
+// message, older entries are dropped to stay under it.
+func SetHistoryTokenBudget(budget int) {
+	historyTokenBudget = budget
+}
+
+// estimateTokenCounter approximates Gemini's tokenizer without an API call:
+// about four characters per token, images at their fixed cost of 258 tokens
+// and other blobs at 258 tokens per started 64KB.
+type estimateTokenCounter struct{}
+
+const entryTokenOverhead = 4
+
+func (estimateTokenCounter) CountTokens(ctx context.Context, entry Conversation) (int, error) {
+	tokens := entryTokenOverhead
+	for _, part := range entry.Parts {
+		switch v := part.(type) {
+		case genai.Text:
+			tokens += estimateTextTokens(string(v))
+		case genai.Blob:
+			tokens += estimateBlobTokens(v)
+		case *genai.Blob:
+			tokens += estimateBlobTokens(*v)
+		default:
+			// function calls and responses are sent as JSON
+			data, err := json.Marshal(part)
+			if err != nil {
+				return 0, err
+			}
+			tokens += estimateTextTokens(string(data))
+		}
+	}
+	return tokens, nil
+}
+
+func estimateTextTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+func estimateBlobTokens(blob genai.Blob) int {
+	if strings.HasPrefix(blob.MIMEType, "image/") {
+		return 258
+	}
+	return 258 * (len(blob.Data)/(64<<10) + 1)
+}
+
+// windowCounter is implemented by counters that can count a whole window in
+// one go, windowStart prefers it over counting entry by entry.
+type windowCounter interface {
+	CountWindow(ctx context.Context, entries []Conversation) ([]int, error)
+}
+
+// ModelTokenCounter asks the model's CountTokens endpoint. Model should be a
+// plain model: every count includes the model's system instruction, tools
+// and safety settings, which are measured once and subtracted as a baseline.
+type ModelTokenCounter struct {
+	Model Model
+
+	mu       sync.Mutex
+	baseline int
+	measured bool
+}
+
+// count returns the tokens of parts without the per request baseline.
+func (c *ModelTokenCounter) count(ctx context.Context, parts []genai.Part) (int, error) {
+	baseline, err := c.measureBaseline(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.Model.CountTokens(ctx, parts...)
+	if err != nil {
+		return 0, err
+	}
+	return max(int(resp.TotalTokens)-baseline, 0), nil
+}
+
+// measureBaseline counts a one token message once, whatever the model adds
+// to it is the baseline. A failed measurement is retried on the next count.
+func (c *ModelTokenCounter) measureBaseline(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.measured {
+		resp, err := c.Model.CountTokens(ctx, genai.Text("a"))
+		if err != nil {
+			return 0, err
+		}
+		c.baseline = max(int(resp.TotalTokens)-1, 0)
+		c.measured = true
+	}
+	return c.baseline, nil
+}
+
+func (c *ModelTokenCounter) CountTokens(ctx context.Context, entry Conversation) (int, error) {
+	return c.count(ctx, entry.Parts)
+}
+
+// CountWindow counts all entries with a single API call and splits the total
+// between them in proportion to their estimates.
+func (c *ModelTokenCounter) CountWindow(ctx context.Context, entries []Conversation) ([]int, error) {
+	estimates := make([]int, len(entries))
+	estimated := 0
+	var parts []genai.Part
+	for i, entry := range entries {
+		tokens, err := estimateTokenCounter{}.CountTokens(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		estimates[i] = tokens
+		estimated += tokens
+		parts = append(parts, entry.Parts...)
+	}
+	if len(parts) == 0 {
+		return estimates, nil
+	}
+
+	total, err := c.count(ctx, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(entries))
+	for i, tokens := range estimates {
+		// rounded up so the window never comes out over budget
+		counts[i] = (tokens*total + estimated - 1) / estimated
+	}
+	return counts, nil
+}
+
+// windowStart returns the index of the first entry to keep so the entries
+// after it fit in budget. The window always starts with a plain user message,
+// so it never begins with a function response whose call was dropped or with
+// a model turn Gemini would reject as the first message.
+func windowStart(ctx context.Context, entries []Conversation, budget int, counter TokenCounter) (int, error) {
+	var counts []int
+	if wc, ok := counter.(windowCounter); ok {
+		var err error
+		if counts, err = wc.CountWindow(ctx, entries); err != nil {
+			return 0, err
+		}
+	}
+
+	start := len(entries)
+	total := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		var tokens int
+		if counts != nil {
+			tokens = counts[i]
+		} else {
+			var err error
+			if tokens, err = counter.CountTokens(ctx, entries[i]); err != nil {
+				return 0, err
+			}
+		}
+		if total+tokens > budget {
+			break
+		}
+		total += tokens
+		start = i
+	}
+
+	for start < len(entries) && !isUserMessage(entries[start]) {
+		start++
+	}
+	return start, nil
+}
+
+func isUserMessage(entry Conversation) bool {
+	if entry.Role != "user" {
+		return false
+	}
+	for _, part := range entry.Parts {
+		switch part.(type) {
+		case genai.FunctionResponse, *genai.FunctionResponse:
+			return false
+		}
+	}
+	return true
+}
+
+// trimChatHistory drops the oldest entries of a chat that don't fit in the
+// history token budget. With a summarizer the dropped entries are folded into
+// the chat's summary first. A stored summary is sent in front of the history
+// even when summarizing is off, so its tokens always count against the budget.
+func trimChatHistory(ctx context.Context, chatID int, counter TokenCounter, summarizer Summarizer) error {
+	entries, err := historyStore.Load(chatID)
+	if err != nil {
+		return err
+	}
+
+	summary, err := historyStore.LoadSummary(chatID)
+	if err != nil {
+		return err
+	}
+	summaryTokens, err := countSummary(ctx, counter, summary)
+	if err != nil {
+		return err
+	}
+
+	start, err := windowStart(ctx, entries, historyTokenBudget-summaryTokens, counter)
+	if err != nil {
+		return err
+	}
+
+	if start == 0 {
+		return nil
+	}
+
+	// the new summary can be longer than the old one, the window then makes
+	// room for it and the entries that no longer fit are summarized as well.
+	// After the second pass they're dropped without, to bound the calls.
+	dropped := entries[:start]
+	for pass := 0; summarizer != nil && pass < 2 && len(dropped) > 0; pass++ {
+		newSummary, err := summarizer.Summarize(ctx, summary, dropped)
+		if err != nil {
+			// trim anyway, losing the turns is better than overflowing the context
+			logWithTime("Error summarizing history for chat %d: %v", chatID, err)
+			break
+		}
+		if err := historyStore.SaveSummary(chatID, newSummary); err != nil {
+			return err
+		}
+		summary = newSummary
+
+		summaryTokens, err := countSummary(ctx, counter, summary)
+		if err != nil {
+			return err
+		}
+		more, err := windowStart(ctx, entries[start:], historyTokenBudget-summaryTokens, counter)
+		if err != nil {
+			return err
+		}
+		dropped = entries[start : start+more]
+		start += more
+	}
+
+	logWithTime("Trimming %d history entries for chat %d", start, chatID)
+	return historyStore.Trim(chatID, len(entries)-start)
+}
+
+// countSummary returns the tokens of the summary pair sent in front of the
+// history, nothing for an empty summary.
+func countSummary(ctx context.Context, counter TokenCounter, summary string) (int, error) {
+	if summary == "" {
+		return 0, nil
+	}
+
+	total := 0
+	for _, entry := range summaryConversation(summary) {
+		tokens, err := counter.CountTokens(ctx, entry)
+		if err != nil {
+			return 0, err
+		}
+		total += tokens
+	}
+	return total, nil
+}
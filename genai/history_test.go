@@ -0,0 +1,382 @@
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func testConversation() []Conversation {
+	return []Conversation{
+		{Role: "user", Parts: []genai.Part{genai.Text("find rust books")}},
+		{Role: "model", Parts: []genai.Part{genai.FunctionCall{
+			Name: "web_search",
+			Args: map[string]any{"query": "rust books", "extract_websites": false},
+		}}},
+		{Role: "function", Parts: []genai.Part{genai.FunctionResponse{
+			Name:     "web_search",
+			Response: map[string]any{"function response: ": `[{"title":"The Book"}]`, "count": float64(1)},
+		}}},
+		{Role: "user", Parts: []genai.Part{
+			genai.Text("what's in this picture?"),
+			genai.Blob{MIMEType: "image/png", Data: []byte{0x89, 'P', 'N', 'G', 0x00}},
+		}},
+		{Role: "model", Parts: []genai.Part{genai.Text("")}},
+	}
+}
+
+func TestConversationJSONRoundTrip(t *testing.T) {
+	want := testConversation()
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	var got []Conversation
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip mismatch\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestConversationJSONPointerParts(t *testing.T) {
+	conv := Conversation{Role: "model", Parts: []genai.Part{&genai.FunctionCall{Name: "read_file", Args: map[string]any{}}}}
+
+	data, err := json.Marshal(conv)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+
+	var got Conversation
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	want := genai.FunctionCall{Name: "read_file", Args: map[string]any{}}
+	if !reflect.DeepEqual(got.Parts[0], want) {
+		t.Errorf("got %#v, want %#v", got.Parts[0], want)
+	}
+}
+
+func TestHistoryStores(t *testing.T) {
+	fileStore, err := NewFileHistoryStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create file store: %v", err)
+	}
+
+	stores := map[string]HistoryStore{
+		"memory": NewMemoryHistoryStore(),
+		"file":   fileStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			entries := testConversation()
+
+			if err := store.Append(1, entries[:2]...); err != nil {
+				t.Fatalf("append failed: %v", err)
+			}
+			if err := store.Append(1, entries[2:]...); err != nil {
+				t.Fatalf("append failed: %v", err)
+			}
+
+			got, err := store.Load(1)
+			if err != nil {
+				t.Fatalf("load failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, entries) {
+				t.Errorf("load mismatch\ngot:  %#v\nwant: %#v", got, entries)
+			}
+
+			if err := store.Trim(1, 2); err != nil {
+				t.Fatalf("trim failed: %v", err)
+			}
+			got, _ = store.Load(1)
+			if !reflect.DeepEqual(got, entries[len(entries)-2:]) {
+				t.Errorf("trim kept %#v", got)
+			}
+
+			if err := store.Delete(1); err != nil {
+				t.Fatalf("delete failed: %v", err)
+			}
+			got, _ = store.Load(1)
+			if len(got) != 0 {
+				t.Errorf("expected empty history after delete, got %d entries", len(got))
+			}
+
+			other, err := store.Load(2)
+			if err != nil || len(other) != 0 {
+				t.Errorf("unknown chat: got %v, %v", other, err)
+			}
+		})
+	}
+}
+
+// fixedTokenCounter charges every entry the same number of tokens.
+type fixedTokenCounter int
+
+func (c fixedTokenCounter) CountTokens(ctx context.Context, entry Conversation) (int, error) {
+	return int(c), nil
+}
+
+func TestWindowStart(t *testing.T) {
+	user := Conversation{Role: "user", Parts: []genai.Part{genai.Text("hi")}}
+	model := Conversation{Role: "model", Parts: []genai.Part{genai.Text("hello")}}
+	call := Conversation{Role: "model", Parts: []genai.Part{genai.FunctionCall{Name: "web_search"}}}
+	response := Conversation{Role: "function", Parts: []genai.Part{genai.FunctionResponse{Name: "web_search"}}}
+	userResponse := Conversation{Role: "user", Parts: []genai.Part{genai.FunctionResponse{Name: "web_search"}}}
+
+	tests := []struct {
+		name    string
+		entries []Conversation
+		budget  int
+		want    int
+	}{
+		{
+			name:    "everything fits",
+			entries: []Conversation{user, model, user, model},
+			budget:  100,
+			want:    0,
+		},
+		{
+			name:    "drops oldest turn",
+			entries: []Conversation{user, model, user, model},
+			budget:  20,
+			want:    2,
+		},
+		{
+			name:    "never starts with a function response",
+			entries: []Conversation{user, call, response, model, user, model},
+			budget:  40,
+			want:    4,
+		},
+		{
+			name:    "never starts with a function call",
+			entries: []Conversation{user, call, response, model, user, model},
+			budget:  50,
+			want:    4,
+		},
+		{
+			name:    "user role function response is not a start",
+			entries: []Conversation{user, call, userResponse, model, user, model},
+			budget:  40,
+			want:    4,
+		},
+		{
+			name:    "nothing fits",
+			entries: []Conversation{user, model},
+			budget:  5,
+			want:    2,
+		},
+		{
+			name:    "empty history",
+			entries: nil,
+			budget:  100,
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := windowStart(context.Background(), tt.entries, tt.budget, fixedTokenCounter(10))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("windowStart() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// overheadModel counts a token per byte of text plus a fixed overhead per
+// call, like a model with a system instruction and tools.
+type overheadModel struct {
+	*fakeModel
+	overhead int
+	calls    int
+}
+
+func (m *overheadModel) CountTokens(ctx context.Context, parts ...genai.Part) (*genai.CountTokensResponse, error) {
+	m.calls++
+	tokens := m.overhead
+	for _, part := range parts {
+		tokens += len(part.(genai.Text))
+	}
+	return &genai.CountTokensResponse{TotalTokens: int32(tokens)}, nil
+}
+
+func TestModelTokenCounter(t *testing.T) {
+	model := &overheadModel{overhead: 500}
+	counter := &ModelTokenCounter{Model: model}
+
+	entry := func(role, text string) Conversation {
+		return Conversation{Role: role, Parts: []genai.Part{genai.Text(text)}}
+	}
+	entries := []Conversation{
+		entry("user", strings.Repeat("a", 40)),
+		entry("model", strings.Repeat("b", 80)),
+		entry("user", strings.Repeat("c", 40)),
+		entry("model", strings.Repeat("d", 40)),
+	}
+
+	tokens, err := counter.CountTokens(context.Background(), entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 40 {
+		t.Errorf("entry counted as %d tokens, want 40 without the overhead", tokens)
+	}
+
+	model.calls = 0
+	start, err := windowStart(context.Background(), entries, 100, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 2 {
+		t.Errorf("windowStart() = %d, want 2", start)
+	}
+	if model.calls != 1 {
+		t.Errorf("counting the window took %d calls, want 1", model.calls)
+	}
+}
+
+type fakeSummarizer struct {
+	previous string
+	dropped  []Conversation
+}
+
+func (s *fakeSummarizer) Summarize(ctx context.Context, previous string, dropped []Conversation) (string, error) {
+	s.previous = previous
+	s.dropped = dropped
+	return "user is called Harsh", nil
+}
+
+func TestTrimChatHistorySummarizes(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	defer SetHistoryStore(historyStore)
+	SetHistoryStore(store)
+
+	oldBudget := historyTokenBudget
+	defer SetHistoryTokenBudget(oldBudget)
+	SetHistoryTokenBudget(40)
+
+	user := Conversation{Role: "user", Parts: []genai.Part{genai.Text("hi")}}
+	model := Conversation{Role: "model", Parts: []genai.Part{genai.Text("hello")}}
+	store.Append(1, user, model, user, model, user, model)
+	store.SaveSummary(1, "earlier summary")
+
+	summarizer := &fakeSummarizer{}
+	if err := trimChatHistory(context.Background(), 1, fixedTokenCounter(10), summarizer); err != nil {
+		t.Fatalf("trim failed: %v", err)
+	}
+
+	// the summary pair costs 20 of the 40 tokens, leaving room for one turn
+	entries, _ := store.Load(1)
+	if len(entries) != 2 {
+		t.Errorf("expected 2 entries left, got %d", len(entries))
+	}
+	if summarizer.previous != "earlier summary" {
+		t.Errorf("summarizer got previous %q", summarizer.previous)
+	}
+	if len(summarizer.dropped) != 4 {
+		t.Errorf("summarizer got %d dropped entries, want 4", len(summarizer.dropped))
+	}
+
+	messages, err := getLastMessages(1)
+	if err != nil {
+		t.Fatalf("getLastMessages failed: %v", err)
+	}
+	if len(messages) != 4 || messages[0].Role != "user" || messages[1].Role != "model" {
+		t.Fatalf("expected summary pair in front of history, got %d messages", len(messages))
+	}
+	if text, _ := messages[0].Parts[0].(genai.Text); !strings.Contains(string(text), "user is called Harsh") {
+		t.Errorf("summary missing from first message: %q", text)
+	}
+}
+
+func TestTrimChatHistoryBudgetsStoredSummary(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	defer SetHistoryStore(historyStore)
+	SetHistoryStore(store)
+
+	oldBudget := historyTokenBudget
+	defer SetHistoryTokenBudget(oldBudget)
+	SetHistoryTokenBudget(40)
+
+	user := Conversation{Role: "user", Parts: []genai.Part{genai.Text("hi")}}
+	model := Conversation{Role: "model", Parts: []genai.Part{genai.Text("hello")}}
+	store.Append(1, user, model, user, model)
+	store.SaveSummary(1, "left over from when summarizing was enabled")
+
+	// without a summarizer the stored summary is still sent, and still costs
+	if err := trimChatHistory(context.Background(), 1, fixedTokenCounter(10), nil); err != nil {
+		t.Fatalf("trim failed: %v", err)
+	}
+	if entries, _ := store.Load(1); len(entries) != 2 {
+		t.Errorf("expected 2 entries left, got %d", len(entries))
+	}
+}
+
+// growingSummarizer returns a longer summary on every call.
+type growingSummarizer struct {
+	calls int
+}
+
+func (s *growingSummarizer) Summarize(ctx context.Context, previous string, dropped []Conversation) (string, error) {
+	s.calls++
+	return previous + strings.Repeat("x", 100), nil
+}
+
+func TestTrimChatHistoryBudgetsNewSummary(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	defer SetHistoryStore(historyStore)
+	SetHistoryStore(store)
+
+	oldBudget := historyTokenBudget
+	defer SetHistoryTokenBudget(oldBudget)
+	SetHistoryTokenBudget(100)
+
+	user := Conversation{Role: "user", Parts: []genai.Part{genai.Text(strings.Repeat("u", 40))}}
+	model := Conversation{Role: "model", Parts: []genai.Part{genai.Text(strings.Repeat("m", 40))}}
+	for i := 0; i < 4; i++ {
+		store.Append(1, user, model)
+	}
+
+	summarizer := &growingSummarizer{}
+	counter := estimateTokenCounter{}
+	if err := trimChatHistory(context.Background(), 1, counter, summarizer); err != nil {
+		t.Fatalf("trim failed: %v", err)
+	}
+
+	entries, _ := store.Load(1)
+	summary, _ := store.LoadSummary(1)
+	total, _ := countSummary(context.Background(), counter, summary)
+	for _, entry := range entries {
+		tokens, _ := counter.CountTokens(context.Background(), entry)
+		total += tokens
+	}
+	if total > 100 {
+		t.Errorf("history and summary take %d tokens, over the budget of 100", total)
+	}
+	if summarizer.calls != 2 {
+		t.Errorf("summarized %d times, want 2 as the summary grew", summarizer.calls)
+	}
+}
+
+func TestTranscriptCutsAtRuneBoundary(t *testing.T) {
+	text := strings.Repeat("é", maxTranscriptPartLength)
+	out := transcript([]Conversation{{Role: "user", Parts: []genai.Part{genai.Text(text)}}})
+	if !utf8.ValidString(out) {
+		t.Error("transcript split a multi-byte rune")
+	}
+}
@@ -0,0 +1,462 @@
+package genai
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	cacheFileSuffix = ".cache"
+	// responses larger than this are never cached
+	maxCacheEntrySize = 10 << 20 // 10 MB
+	// cap for the heuristic freshness of responses with only Last-Modified
+	maxHeuristicFreshness = 24 * time.Hour
+)
+
+var (
+	// how long search result pages are reused, whatever their headers say
+	searchCacheTTL = 10 * time.Minute
+	// same for scraped pages, 0 follows the response's caching headers
+	pageCacheTTL time.Duration
+)
+
+// SetSearchCacheTTL sets how long cached search result pages are reused.
+func SetSearchCacheTTL(ttl time.Duration) {
+	searchCacheTTL = ttl
+}
+
+// SetPageCacheTTL sets how long cached pages are reused, 0 follows the
+// Cache-Control, Expires and Last-Modified headers of every page.
+func SetPageCacheTTL(ttl time.Duration) {
+	pageCacheTTL = ttl
+}
+
+type cacheTTLKey struct{}
+
+// withCacheTTL makes cached responses to requests made with ctx fresh for
+// ttl, overriding the response headers.
+func withCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	if ttl <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, cacheTTLKey{}, ttl)
+}
+
+// HTTPCache is an http.RoundTripper caching GET responses on disk. It
+// follows Cache-Control and Expires, revalidates stale responses with
+// their ETag or Last-Modified and evicts the least recently used responses
+// once the directory grows past maxBytes.
+type HTTPCache struct {
+	dirPath  string
+	maxBytes int64
+	base     http.RoundTripper
+
+	mu    sync.Mutex
+	lru   *list.List // of *cacheItem, most recently used first
+	items map[string]*list.Element
+	size  int64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	revalidations atomic.Int64
+}
+
+type cacheItem struct {
+	key  string
+	size int64
+}
+
+// cacheEntry is the first line of a cache file, the body follows it.
+type cacheEntry struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	StoredAt   time.Time   `json:"stored_at"`
+	Expires    time.Time   `json:"expires"`
+}
+
+func NewHTTPCache(dirPath string, maxBytes int64) (*HTTPCache, error) {
+	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	c := &HTTPCache{
+		dirPath:  dirPath,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+	}
+
+	if err := c.loadIndex(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetHTTPCache makes search and scraping requests go through cache.
+func SetHTTPCache(cache *HTTPCache) {
+	cache.base = webClient.Transport
+	webClient.Transport = cache
+}
+
+// loadIndex rebuilds the LRU order from the files' modification times,
+// which are bumped on every hit.
+func (c *HTTPCache) loadIndex() error {
+	entries, err := os.ReadDir(c.dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %v", err)
+	}
+
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// left over from a crash
+			os.Remove(filepath.Join(c.dirPath, name))
+			continue
+		}
+		if !strings.HasSuffix(name, cacheFileSuffix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{strings.TrimSuffix(name, cacheFileSuffix), info.Size(), info.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files {
+		c.items[f.key] = c.lru.PushBack(&cacheItem{key: f.key, size: f.size})
+		c.size += f.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *HTTPCache) path(key string) string {
+	return filepath.Join(c.dirPath, key+cacheFileSuffix)
+}
+
+func (c *HTTPCache) transport() http.RoundTripper {
+	if c.base != nil {
+		return c.base
+	}
+	return http.DefaultTransport
+}
+
+// cacheKey identifies a response by URL and the headers that change what
+// a server sends back.
+func cacheKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Accept") + "\n" + req.Header.Get("Accept-Language")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *HTTPCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return c.transport().RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+	entry, body, cached := c.load(key)
+
+	if cached && time.Now().Before(entry.Expires) {
+		c.hits.Add(1)
+		c.logStats("hit", req)
+		return entry.response(req, body), nil
+	}
+
+	if cached && (entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "") {
+		conditional := req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			conditional.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			conditional.Header.Set("If-Modified-Since", lastModified)
+		}
+
+		res, err := c.transport().RoundTrip(conditional)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusNotModified {
+			res.Body.Close()
+			c.revalidations.Add(1)
+			c.logStats("revalidated", req)
+
+			// a 304 carries the updated caching headers
+			for _, name := range []string{"Cache-Control", "Date", "Expires", "ETag", "Last-Modified"} {
+				if value := res.Header.Get(name); value != "" {
+					entry.Header.Set(name, value)
+				}
+			}
+			if ttl, ok := freshness(req.Context(), entry.Header, time.Now()); ok {
+				entry.StoredAt = time.Now()
+				entry.Expires = entry.StoredAt.Add(ttl)
+				if err := c.store(key, entry, body); err != nil {
+					logWithTime("[httpCache] Failed to update %s: %v", req.URL, err)
+				}
+			}
+			return entry.response(req, body), nil
+		}
+
+		c.misses.Add(1)
+		c.logStats("miss", req)
+		return c.storeResponse(req, key, res)
+	}
+
+	res, err := c.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.misses.Add(1)
+	c.logStats("miss", req)
+	return c.storeResponse(req, key, res)
+}
+
+func (c *HTTPCache) logStats(event string, req *http.Request) {
+	logWithTime("[httpCache] %s %s (hits %d, revalidated %d, misses %d)",
+		event, req.URL, c.hits.Load(), c.revalidations.Load(), c.misses.Load())
+}
+
+// storeResponse caches res if its headers allow it and returns a response
+// with an unread body.
+func (c *HTTPCache) storeResponse(req *http.Request, key string, res *http.Response) (*http.Response, error) {
+	if res.StatusCode != http.StatusOK {
+		return res, nil
+	}
+
+	now := time.Now()
+	ttl, ok := freshness(req.Context(), res.Header, now)
+	if !ok {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxCacheEntrySize+1))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	if len(body) > maxCacheEntrySize || int64(len(body)) > c.maxBytes {
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return res, nil
+	}
+	res.Body.Close()
+
+	entry := &cacheEntry{
+		URL:        req.URL.String(),
+		StatusCode: res.StatusCode,
+		Header:     res.Header.Clone(),
+		StoredAt:   now,
+		Expires:    now.Add(ttl),
+	}
+	if err := c.store(key, entry, body); err != nil {
+		logWithTime("[httpCache] Failed to store %s: %v", req.URL, err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// freshness returns how long a response stays fresh and whether it may be
+// cached at all.
+func freshness(ctx context.Context, header http.Header, now time.Time) (time.Duration, bool) {
+	if ttl, ok := ctx.Value(cacheTTLKey{}).(time.Duration); ok {
+		return ttl, true
+	}
+
+	directives := cacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok || header.Get("Vary") == "*" {
+		return 0, false
+	}
+
+	hasValidator := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+
+	if _, ok := directives["no-cache"]; ok {
+		return 0, hasValidator
+	}
+
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err == nil {
+			age, _ := strconv.Atoi(header.Get("Age"))
+			ttl := time.Duration(seconds-age) * time.Second
+			return max(ttl, 0), ttl > 0 || hasValidator
+		}
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		// an invalid Expires, like "0", means already expired
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(date) {
+			return 0, hasValidator
+		}
+		return t.Sub(date), true
+	}
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		return min(date.Sub(lastModified)/10, maxHeuristicFreshness), true
+	}
+
+	return 0, hasValidator
+}
+
+func cacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func (e *cacheEntry) response(req *http.Request, body []byte) *http.Response {
+	header := e.Header.Clone()
+	header.Set("X-Cache", "HIT")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func (c *HTTPCache) load(key string) (*cacheEntry, []byte, bool) {
+	c.mu.Lock()
+	element, ok := c.items[key]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		// evicted in the meantime
+		return nil, nil, false
+	}
+
+	header, body, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return nil, nil, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(header, &entry); err != nil {
+		logWithTime("[httpCache] Corrupt cache file %s: %v", c.path(key), err)
+		return nil, nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	return &entry, body, true
+}
+
+// store writes the entry through a rename, so readers never see a partial
+// file, then evicts old entries if the cache grew too large.
+func (c *HTTPCache) store(key string, entry *cacheEntry, body []byte) error {
+	header, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode entry: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(c.dirPath, "entry-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.Write(header)
+	w.WriteByte('\n')
+	w.Write(body)
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write entry: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write entry: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return fmt.Errorf("failed to write entry: %v", err)
+	}
+
+	size := int64(len(header) + 1 + len(body))
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*cacheItem)
+		c.size += size - item.size
+		item.size = size
+		c.lru.MoveToFront(element)
+	} else {
+		c.items[key] = c.lru.PushFront(&cacheItem{key: key, size: size})
+		c.size += size
+	}
+
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxBytes, c.mu must be held.
+func (c *HTTPCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		element := c.lru.Back()
+		item := element.Value.(*cacheItem)
+
+		if err := os.Remove(c.path(item.key)); err != nil && !os.IsNotExist(err) {
+			logWithTime("[httpCache] Failed to evict %s: %v", item.key, err)
+		}
+		c.lru.Remove(element)
+		delete(c.items, item.key)
+		c.size -= item.size
+	}
+}
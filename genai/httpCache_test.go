@@ -0,0 +1,221 @@
+package genai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func cacheClient(t *testing.T, maxBytes int64) (*http.Client, *HTTPCache, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	cache, err := NewHTTPCache(dir, maxBytes)
+	if err != nil {
+		t.Fatalf("NewHTTPCache failed: %v", err)
+	}
+	return &http.Client{Transport: cache}, cache, dir
+}
+
+func fetch(t *testing.T, client *http.Client, ctx context.Context, url string) (string, *http.Response) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return string(body), res
+}
+
+func TestHTTPCache(t *testing.T) {
+	var requests, notModified atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/expired":
+			w.Header().Set("Expires", "0")
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "body of %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path         string
+		ctx          context.Context
+		wantRequests int64
+		wantCached   bool
+	}{
+		{path: "/fresh", wantRequests: 1, wantCached: true},
+		// revalidated every time, but the body comes from the cache
+		{path: "/etag", wantRequests: 3, wantCached: true},
+		{path: "/no-store", wantRequests: 3},
+		{path: "/expired", wantRequests: 3},
+		{path: "/missing", wantRequests: 3},
+		// the override wins over the headers
+		{path: "/no-store", ctx: withCacheTTL(context.Background(), time.Minute), wantRequests: 1, wantCached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			client, _, _ := cacheClient(t, 1<<20)
+			requests.Store(0)
+
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			for i := 0; i < 3; i++ {
+				body, res := fetch(t, client, ctx, server.URL+tt.path)
+				if res.StatusCode == http.StatusOK && body != "body of "+tt.path {
+					t.Errorf("request %d: body = %q", i, body)
+				}
+				if cached := res.Header.Get("X-Cache") == "HIT"; i > 0 && cached != tt.wantCached {
+					t.Errorf("request %d: cached = %v, want %v", i, cached, tt.wantCached)
+				}
+			}
+
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("server got %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+
+	if notModified.Load() != 2 {
+		t.Errorf("got %d conditional requests, want 2", notModified.Load())
+	}
+}
+
+func TestHTTPCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=600")
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer server.Close()
+
+	// room for two entries but not three
+	client, cache, dir := cacheClient(t, 2600)
+	ctx := context.Background()
+
+	fetch(t, client, ctx, server.URL+"/a")
+	fetch(t, client, ctx, server.URL+"/b")
+	// a is used again so b is now the oldest
+	fetch(t, client, ctx, server.URL+"/a")
+	fetch(t, client, ctx, server.URL+"/c")
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+cacheFileSuffix))
+	if len(files) != 2 {
+		t.Errorf("got %d cache files, want 2", len(files))
+	}
+
+	for path, want := range map[string]bool{"/a": true, "/b": false, "/c": true} {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		if _, ok := cache.items[cacheKey(req)]; ok != want {
+			t.Errorf("%s cached = %v, want %v", path, ok, want)
+		}
+	}
+
+	// the index is rebuilt from disk on restart
+	reopened, err := NewHTTPCache(dir, 2600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.lru.Len() != 2 || reopened.size != cache.size {
+		t.Errorf("reopened cache has %d entries and %d bytes, want 2 and %d", reopened.lru.Len(), reopened.size, cache.size)
+	}
+	_, res := fetch(t, &http.Client{Transport: reopened}, ctx, server.URL+"/c")
+	if res.Header.Get("X-Cache") != "HIT" {
+		t.Error("expected a hit after reopening the cache")
+	}
+}
+
+func TestHTTPCacheIgnoresTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	tmp := filepath.Join(dir, "entry-123.tmp")
+	if err := os.WriteFile(tmp, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewHTTPCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.lru.Len() != 0 {
+		t.Errorf("temp file was indexed")
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temp file was not removed")
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+
+	tests := []struct {
+		name      string
+		header    map[string]string
+		wantTTL   time.Duration
+		wantStore bool
+	}{
+		{"max-age", map[string]string{"Cache-Control": "max-age=300"}, 5 * time.Minute, true},
+		{"max-age minus age", map[string]string{"Cache-Control": "max-age=300", "Age": "100"}, 200 * time.Second, true},
+		{"no-store", map[string]string{"Cache-Control": "no-store, max-age=300"}, 0, false},
+		{"no-cache with etag", map[string]string{"Cache-Control": "no-cache", "ETag": `"x"`}, 0, true},
+		{"no-cache without validator", map[string]string{"Cache-Control": "no-cache"}, 0, false},
+		{"expires", map[string]string{"Date": date, "Expires": now.Add(time.Hour).Format(http.TimeFormat)}, time.Hour, true},
+		{"invalid expires", map[string]string{"Date": date, "Expires": "0"}, 0, false},
+		{"last-modified heuristic", map[string]string{"Date": date, "Last-Modified": now.Add(-10 * time.Hour).Format(http.TimeFormat)}, time.Hour, true},
+		{"heuristic capped", map[string]string{"Date": date, "Last-Modified": now.AddDate(-1, 0, 0).Format(http.TimeFormat)}, maxHeuristicFreshness, true},
+		{"nothing", map[string]string{}, 0, false},
+		{"vary star", map[string]string{"Cache-Control": "max-age=300", "Vary": "*"}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+
+			ttl, store := freshness(context.Background(), header, now)
+			if ttl != tt.wantTTL || store != tt.wantStore {
+				t.Errorf("freshness() = %v, %v, want %v, %v", ttl, store, tt.wantTTL, tt.wantStore)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package genai
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ProductData is what a JSON-LD Product block says about a product.
+type ProductData struct {
+	Name         string  `json:"name,omitempty"`
+	Brand        string  `json:"brand,omitempty"`
+	SKU          string  `json:"sku,omitempty"`
+	Price        float64 `json:"price,omitempty"`
+	Currency     string  `json:"currency,omitempty"`
+	Availability string  `json:"availability,omitempty"`
+	Rating       float64 `json:"rating,omitempty"`
+	ReviewCount  int     `json:"review_count,omitempty"`
+}
+
+// pageMetadata fills the metadata fields of data from the page's JSON-LD,
+// OpenGraph and Twitter card tags and plain HTML, in that order of trust.
+func pageMetadata(doc *goquery.Document, pageURL string, data *WebPageData) {
+	meta := func(names ...string) string {
+		for _, name := range names {
+			selector := `meta[property="` + name + `"], meta[name="` + name + `"], meta[itemprop="` + name + `"]`
+			if content := trimContent(doc.Find(selector).First().AttrOr("content", "")); content != "" {
+				return content
+			}
+		}
+		return ""
+	}
+
+	ld := jsonLD(doc)
+
+	data.Type = firstNonEmpty(ld.typ, meta("og:type"))
+	data.Title = firstNonEmpty(ld.headline, meta("og:title", "twitter:title"), trimContent(doc.Find("title").First().Text()))
+	data.Description = firstNonEmpty(meta("description", "og:description", "twitter:description"), ld.description)
+	data.Author = firstNonEmpty(ld.author, meta("author", "article:author", "twitter:creator"))
+	data.SiteName = firstNonEmpty(meta("og:site_name"), ld.publisher)
+	data.Image = resolveURL(pageURL, firstNonEmpty(meta("og:image", "twitter:image"), ld.image))
+
+	data.Published = normalizeDate(firstNonEmpty(
+		ld.published,
+		meta("article:published_time", "datePublished", "date"),
+		doc.Find("time[datetime]").First().AttrOr("datetime", ""),
+	))
+	data.Modified = normalizeDate(firstNonEmpty(ld.modified, meta("article:modified_time", "og:updated_time", "dateModified")))
+
+	canonical := doc.Find(`link[rel="canonical"]`).First().AttrOr("href", "")
+	data.CanonicalURL = resolveURL(pageURL, firstNonEmpty(strings.TrimSpace(canonical), meta("og:url")))
+
+	lang := doc.Find("html").First().AttrOr("lang", "")
+	doc.Find("meta[http-equiv]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if lang == "" && strings.EqualFold(s.AttrOr("http-equiv", ""), "content-language") {
+			lang = s.AttrOr("content", "")
+		}
+		return lang == ""
+	})
+	data.Language = firstNonEmpty(strings.TrimSpace(lang), strings.ReplaceAll(meta("og:locale"), "_", "-"), ld.language)
+
+	data.Product = ld.product
+}
+
+// ldData is the subset of JSON-LD we care about, taken from the first
+// article or product block on the page.
+type ldData struct {
+	typ         string
+	headline    string
+	description string
+	author      string
+	publisher   string
+	image       string
+	published   string
+	modified    string
+	language    string
+	product     *ProductData
+}
+
+func jsonLD(doc *goquery.Document) ldData {
+	var nodes []map[string]any
+	doc.Find(`script[type="application/ld+json"]`).Each(func(i int, s *goquery.Selection) {
+		var v any
+		if err := json.Unmarshal([]byte(s.Text()), &v); err != nil {
+			logWithTime("[pageMetadata] Invalid JSON-LD: %v", err)
+			return
+		}
+		nodes = append(nodes, ldNodes(v)...)
+	})
+
+	var ld ldData
+	for _, node := range nodes {
+		typ := ldType(node)
+		switch {
+		case typ == "Product":
+			if ld.product != nil {
+				continue
+			}
+			ld.product = ldProduct(node)
+			if ld.typ == "" {
+				ld.typ = typ
+				ld.headline = ldString(node["name"])
+				ld.description = ldString(node["description"])
+				ld.image = ldString(node["image"])
+			}
+		case strings.HasSuffix(typ, "Article") || typ == "BlogPosting" || typ == "Report":
+			if ld.typ != "" && ld.typ != "Product" {
+				continue
+			}
+			ld.typ = typ
+			ld.headline = firstNonEmpty(ldString(node["headline"]), ldString(node["name"]))
+			ld.description = ldString(node["description"])
+			ld.author = ldNames(node["author"])
+			ld.publisher = ldString(node["publisher"])
+			ld.image = ldString(node["image"])
+			ld.published = ldString(node["datePublished"])
+			ld.modified = ldString(node["dateModified"])
+			ld.language = ldString(node["inLanguage"])
+		}
+	}
+	return ld
+}
+
+// ldNodes flattens arrays and @graph containers into single objects.
+func ldNodes(v any) []map[string]any {
+	switch v := v.(type) {
+	case []any:
+		var nodes []map[string]any
+		for _, item := range v {
+			nodes = append(nodes, ldNodes(item)...)
+		}
+		return nodes
+	case map[string]any:
+		if graph, ok := v["@graph"]; ok {
+			return ldNodes(graph)
+		}
+		return []map[string]any{v}
+	}
+	return nil
+}
+
+// ldType returns the first @type of node without any schema.org prefix.
+func ldType(node map[string]any) string {
+	typ := ldString(node["@type"])
+	return typ[strings.LastIndex(typ, "/")+1:]
+}
+
+func ldProduct(node map[string]any) *ProductData {
+	product := &ProductData{
+		Name:  ldString(node["name"]),
+		Brand: ldString(node["brand"]),
+		SKU:   ldString(node["sku"]),
+	}
+
+	offers := ldNodes(node["offers"])
+	if len(offers) > 0 {
+		offer := offers[0]
+		product.Price = ldNumber(firstNonNil(offer["price"], offer["lowPrice"]))
+		product.Currency = ldString(offer["priceCurrency"])
+		availability := ldString(offer["availability"])
+		product.Availability = availability[strings.LastIndex(availability, "/")+1:]
+	}
+
+	if rating, ok := node["aggregateRating"].(map[string]any); ok {
+		product.Rating = ldNumber(rating["ratingValue"])
+		product.ReviewCount = int(ldNumber(firstNonNil(rating["reviewCount"], rating["ratingCount"])))
+	}
+
+	return product
+}
+
+// ldString returns a JSON-LD value as text, for objects their name, url or
+// @value and for arrays their first item.
+func ldString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return trimContent(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		for _, item := range v {
+			if s := ldString(item); s != "" {
+				return s
+			}
+		}
+	case map[string]any:
+		for _, key := range []string{"name", "url", "@value"} {
+			if s := ldString(v[key]); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// ldNames joins the names of one or more people.
+func ldNames(v any) string {
+	items, ok := v.([]any)
+	if !ok {
+		return ldString(v)
+	}
+
+	var names []string
+	for _, item := range items {
+		if name := ldString(item); name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func ldNumber(v any) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		n, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(v), ",", ""), 64)
+		return n
+	}
+	return 0
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+var dayLayouts = []string{
+	"2006-01-02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+}
+
+// normalizeDate returns dates as RFC 3339, or YYYY-MM-DD when there is no
+// time of day. Dates it can't parse are returned as they are.
+func normalizeDate(date string) string {
+	date = strings.TrimSpace(date)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	for _, layout := range dayLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return date
+}
+
+// resolveURL makes ref absolute relative to the page it was found on.
+func resolveURL(pageURL string, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func firstNonNil(values ...any) any {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package genai
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestPageMetadata(t *testing.T) {
+	tests := []struct {
+		page string
+		url  string
+		want WebPageData
+	}{
+		{
+			page: "article.html",
+			url:  "https://news.example.com/business/2024/05/rates?utm_source=feed",
+			want: WebPageData{
+				CanonicalURL: "https://news.example.com/business/rates-held-steady",
+				Type:         "NewsArticle",
+				Title:        "Central bank holds interest rates steady",
+				Description:  "The central bank kept rates unchanged for a third month.",
+				Author:       "Jane Doe, John Roe",
+				SiteName:     "Example News",
+				Language:     "en-GB",
+				Published:    "2024-05-02T09:30:00+01:00",
+				Modified:     "2024-05-02T11:00:00Z",
+				Image:        "https://news.example.com/images/bank.jpg",
+			},
+		},
+		{
+			page: "product.html",
+			url:  "https://shop.example.com/p/trail-runner-2?ref=search",
+			want: WebPageData{
+				CanonicalURL: "https://shop.example.com/p/trail-runner-2",
+				Type:         "Product",
+				Title:        "Trail Runner 2",
+				Description:  "A lightweight trail running shoe.",
+				Language:     "de",
+				Image:        "https://shop.example.com/img/tr2.jpg",
+				Product: &ProductData{
+					Name:         "Trail Runner 2",
+					Brand:        "Acme",
+					SKU:          "TR2-42",
+					Price:        129.9,
+					Currency:     "EUR",
+					Availability: "InStock",
+					Rating:       4.6,
+					ReviewCount:  87,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "metadata", tt.page))
+			if err != nil {
+				t.Fatalf("failed to read page: %v", err)
+			}
+
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(data)))
+			if err != nil {
+				t.Fatalf("failed to parse page: %v", err)
+			}
+
+			var got WebPageData
+			pageMetadata(doc, tt.url, &got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metadata mismatch\ngot:  %+v\nwant: %+v", got, tt.want)
+				if got.Product != nil && tt.want.Product != nil {
+					t.Errorf("product\ngot:  %+v\nwant: %+v", *got.Product, *tt.want.Product)
+				}
+			}
+		})
+	}
+}
+
+func TestPageMetadataFallbacks(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head>
+		<title> Plain page </title>
+		<meta name="author" content="Sam">
+		</head><body><time datetime="March 5, 2023">March 5</time></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got WebPageData
+	pageMetadata(doc, "https://example.com/", &got)
+
+	if got.Title != "Plain page" || got.Author != "Sam" || got.Published != "2023-03-05" {
+		t.Errorf("unexpected metadata %+v", got)
+	}
+}
+
+func TestNormalizeDate(t *testing.T) {
+	tests := map[string]string{
+		"2024-05-02T09:30:00Z":          "2024-05-02T09:30:00Z",
+		"2024-05-02T09:30:00+0200":      "2024-05-02T09:30:00+02:00",
+		"2024-05-02T09:30":              "2024-05-02T09:30:00Z",
+		"Thu, 02 May 2024 09:30:00 GMT": "2024-05-02T09:30:00Z",
+		" 2024-05-02 ":                  "2024-05-02",
+		"May 2, 2024":                   "2024-05-02",
+		"last Tuesday":                  "last Tuesday",
+	}
+
+	for date, want := range tests {
+		if got := normalizeDate(date); got != want {
+			t.Errorf("normalizeDate(%q) = %q, want %q", date, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package genai
+
+import (
+	"context"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// modelName is the default model, see Config
+const modelName = "gemini-2.0-flash-exp"
+
+// ChatSession is what a turn needs of *genai.ChatSession, so the agent loop
+// can run against a fake model.
+type ChatSession interface {
+	SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+	SendMessageStream(ctx context.Context, parts ...genai.Part) ResponseIterator
+}
+
+// ResponseIterator is what sendStream needs of
+// *genai.GenerateContentResponseIterator.
+type ResponseIterator interface {
+	// Next returns the next chunk of the response, iterator.Done at the end.
+	Next() (*genai.GenerateContentResponse, error)
+	// MergedResponse is the chunks returned so far as one response.
+	MergedResponse() *genai.GenerateContentResponse
+}
+
+// Model is what the handler needs of a Gemini model. Like TelegramBot it is
+// an interface so tests can fake it.
+type Model interface {
+	// StartChat starts a chat that continues from history.
+	StartChat(history []*genai.Content) ChatSession
+	GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+	CountTokens(ctx context.Context, parts ...genai.Part) (*genai.CountTokensResponse, error)
+}
+
+// ModelFactory hands out the models used to answer messages. It is shared
+// by every request so implementations must be safe for concurrent use.
+type ModelFactory interface {
+	// ChatModel answers users, with the system prompt and the tools.
+	ChatModel() Model
+	// PlainModel has no tools or system prompt, for summaries.
+	PlainModel() Model
+}
+
+// GeminiModels is a ModelFactory backed by one Gemini client. The models are
+// configured once and only read afterwards, which makes them safe to share.
+type GeminiModels struct {
+	client *genai.Client
+	chat   geminiModel
+	plain  geminiModel
+}
+
+// NewGeminiModels creates the Gemini client and configures the models from
+// cfg. Tools must be registered and disabled before, they are declared to
+// the chat model here.
+func NewGeminiModels(ctx context.Context, apiKey string, cfg Config) (*GeminiModels, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+
+	chat := client.GenerativeModel(cfg.Model)
+	chat.SystemInstruction = genai.NewUserContent(genai.Text(cfg.SystemPrompt()))
+	chat.Tools = chatTools()
+	chat.SafetySettings = cfg.safetySettings()
+	chat.Temperature = cfg.Generation.Temperature
+	chat.TopP = cfg.Generation.TopP
+	chat.MaxOutputTokens = cfg.Generation.MaxOutputTokens
+
+	return &GeminiModels{
+		client: client,
+		chat:   geminiModel{chat},
+		plain:  geminiModel{client.GenerativeModel(cfg.Model)},
+	}, nil
+}
+
+func (m *GeminiModels) ChatModel() Model {
+	return m.chat
+}
+
+func (m *GeminiModels) PlainModel() Model {
+	return m.plain
+}
+
+// Close closes the Gemini client.
+func (m *GeminiModels) Close() error {
+	return m.client.Close()
+}
+
+// chatTools declares the enabled tools to the chat model. Gemini rejects a
+// tool without declarations, so there is none when every tool is disabled.
+func chatTools() []*genai.Tool {
+	tools := toolDeclarations()
+	if len(tools.FunctionDeclarations) == 0 {
+		return nil
+	}
+	return []*genai.Tool{tools}
+}
+
+// geminiModel adapts *genai.GenerativeModel to Model.
+type geminiModel struct {
+	*genai.GenerativeModel
+}
+
+func (m geminiModel) StartChat(history []*genai.Content) ChatSession {
+	cs := m.GenerativeModel.StartChat()
+	cs.History = history
+	return geminiChat{cs}
+}
+
+// geminiChat adapts *genai.ChatSession to ChatSession.
+type geminiChat struct {
+	*genai.ChatSession
+}
+
+func (c geminiChat) SendMessageStream(ctx context.Context, parts ...genai.Part) ResponseIterator {
+	return c.ChatSession.SendMessageStream(ctx, parts...)
+}
@@ -0,0 +1,222 @@
+package genai
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractor names recorded in WebPageData.Extractor
+const (
+	extractorHTML = "html"
+	extractorPDF  = "pdf"
+	extractorJSON = "json"
+	extractorText = "text"
+	extractorFeed = "feed"
+)
+
+const (
+	// longest content returned for documents that aren't html
+	maxPageContentChars = 50000
+	maxFeedItems        = 20
+	maxFeedSummaryChars = 300
+)
+
+// FeedItem is one entry of an RSS or Atom feed.
+type FeedItem struct {
+	Title     string `json:"title"`
+	Link      string `json:"link,omitempty"`
+	Published string `json:"published,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+}
+
+// extractPage fills data from a downloaded body, picking the extractor from
+// the Content-Type and falling back to sniffing the body.
+func extractPage(pageURL string, contentType string, body []byte, data *WebPageData) error {
+	mediaType := pageMediaType(pageURL, contentType, body)
+
+	switch {
+	case mediaType == "application/pdf":
+		data.Extractor = extractorPDF
+		text, err := pdfText(body)
+		if err != nil {
+			return err
+		}
+		data.Content = pageContent(strings.TrimSpace(text))
+
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		data.Extractor = extractorJSON
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, body, "", "  "); err != nil {
+			return fmt.Errorf("invalid json: %v", err)
+		}
+		data.Content = pageContent(pretty.String())
+
+	case mediaType == "application/rss+xml" || mediaType == "application/atom+xml" ||
+		strings.HasSuffix(mediaType, "xml") && isFeed(body):
+		data.Extractor = extractorFeed
+		return feedPage(body, data)
+
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		data.Extractor = extractorHTML
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error parsing document: %v", err)
+		}
+		pageMetadata(doc, pageURL, data)
+		// extraction removes boilerplate from doc, so it goes last
+		data.Content = extractMainContent(doc)
+
+	case strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "xml"):
+		data.Extractor = extractorText
+		if !utf8.Valid(body) {
+			return fmt.Errorf("%s content is not valid UTF-8", mediaType)
+		}
+		data.Content = pageContent(strings.TrimSpace(string(body)))
+
+	default:
+		return fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	return nil
+}
+
+func pageContent(text string) string {
+	if len(text) > maxPageContentChars {
+		return truncateText(text, maxPageContentChars) + "\n\n[content truncated]"
+	}
+	return text
+}
+
+// pageMediaType returns the media type of a body, servers often send
+// binary files as application/octet-stream or without a Content-Type.
+func pageMediaType(pageURL string, contentType string, body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return strings.ToLower(mediaType)
+	}
+
+	if strings.EqualFold(path.Ext(strings.SplitN(pageURL, "?", 2)[0]), ".pdf") {
+		return "application/pdf"
+	}
+
+	mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	return mediaType
+}
+
+// isFeed reports whether an xml document is an RSS or Atom feed.
+func isFeed(body []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "rss" || start.Name.Local == "feed" || start.Name.Local == "RDF"
+		}
+	}
+}
+
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+// feedDocument covers RSS 2.0, RSS 1.0 (RDF) and Atom, only the fields of
+// the format in use are set.
+type feedDocument struct {
+	XMLName xml.Name
+	// RSS
+	Channel struct {
+		Title       string     `xml:"title"`
+		Description string     `xml:"description"`
+		Language    string     `xml:"language"`
+		Items       []feedItem `xml:"item"`
+	} `xml:"channel"`
+	Items []feedItem `xml:"item"`
+	// Atom
+	Title    string     `xml:"title"`
+	Subtitle string     `xml:"subtitle"`
+	Lang     string     `xml:"lang,attr"`
+	Entries  []feedItem `xml:"entry"`
+}
+
+type feedItem struct {
+	Title       string     `xml:"title"`
+	Links       []feedLink `xml:"link"`
+	PubDate     string     `xml:"pubDate"`
+	Date        string     `xml:"date"`
+	Published   string     `xml:"published"`
+	Updated     string     `xml:"updated"`
+	Description string     `xml:"description"`
+	Summary     string     `xml:"summary"`
+	Content     string     `xml:"content"`
+}
+
+func feedPage(body []byte, data *WebPageData) error {
+	var feed feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	// feeds in other encodings are rare, read them as they are
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&feed); err != nil {
+		return fmt.Errorf("invalid feed: %v", err)
+	}
+
+	items := append(append(feed.Channel.Items, feed.Items...), feed.Entries...)
+
+	data.Type = "feed"
+	data.Title = trimContent(firstNonEmpty(feed.Channel.Title, feed.Title))
+	data.Description = feedSummary(firstNonEmpty(feed.Channel.Description, feed.Subtitle))
+	data.Language = firstNonEmpty(feed.Channel.Language, feed.Lang)
+
+	for _, item := range items {
+		if len(data.Items) == maxFeedItems {
+			break
+		}
+
+		data.Items = append(data.Items, FeedItem{
+			Title:     trimContent(item.Title),
+			Link:      item.link(),
+			Published: normalizeDate(firstNonEmpty(item.PubDate, item.Published, item.Date, item.Updated)),
+			Summary:   feedSummary(firstNonEmpty(item.Description, item.Summary, item.Content)),
+		})
+	}
+
+	return nil
+}
+
+// link returns the RSS link, or the Atom link pointing to the entry itself.
+func (item feedItem) link() string {
+	for _, l := range item.Links {
+		if l.Href == "" && strings.TrimSpace(l.Text) != "" {
+			return strings.TrimSpace(l.Text)
+		}
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// feedSummary turns a feed description, often escaped html, into a short
+// plain text summary.
+func feedSummary(description string) string {
+	text, err := htmlText([]byte(description))
+	if err != nil {
+		text = description
+	}
+	return truncateText(trimContent(text), maxFeedSummaryChars)
+}
@@ -0,0 +1,234 @@
+package genai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const rssFeed = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+  <title>Example Blog</title>
+  <description>Posts about &lt;b&gt;Go&lt;/b&gt;</description>
+  <language>en-us</language>
+  <item>
+    <title>Go 1.23 released</title>
+    <link>https://blog.example.com/go-1-23</link>
+    <pubDate>Tue, 13 Aug 2024 10:00:00 +0000</pubDate>
+    <description>&lt;p&gt;Range over &lt;em&gt;functions&lt;/em&gt; is here.&lt;/p&gt;</description>
+  </item>
+  <item>
+    <title>Second post</title>
+    <link>https://blog.example.com/second</link>
+    <dc:date>2024-08-01</dc:date>
+  </item>
+</channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="fr">
+  <title>Atom Example</title>
+  <subtitle>An Atom feed</subtitle>
+  <entry>
+    <title>First entry</title>
+    <link rel="self" href="https://example.com/entries/1.atom"/>
+    <link rel="alternate" href="https://example.com/entries/1"/>
+    <published>2024-02-03T04:05:06Z</published>
+    <updated>2024-02-04T04:05:06Z</updated>
+    <summary>Short summary</summary>
+  </entry>
+</feed>`
+
+func TestScrapeWebPageContentTypes(t *testing.T) {
+	pdf := renderPDF("# Annual report\n\nRevenue grew by ten percent.")
+
+	tests := []struct {
+		name          string
+		path          string
+		contentType   string
+		body          []byte
+		wantExtractor string
+		wantContent   []string
+		check         func(t *testing.T, data *WebPageData)
+	}{
+		{
+			name:          "html",
+			path:          "/page",
+			contentType:   "text/html; charset=utf-8",
+			body:          []byte("<html><head><title>Hello</title></head><body><p>Hello from a web page.</p></body></html>"),
+			wantExtractor: extractorHTML,
+			wantContent:   []string{"Hello from a web page."},
+		},
+		{
+			name:          "pdf",
+			path:          "/report.pdf",
+			contentType:   "application/pdf",
+			body:          pdf,
+			wantExtractor: extractorPDF,
+			wantContent:   []string{"Annual report", "Revenue grew"},
+		},
+		{
+			name:          "pdf served as octet-stream",
+			path:          "/download.pdf",
+			contentType:   "application/octet-stream",
+			body:          pdf,
+			wantExtractor: extractorPDF,
+			wantContent:   []string{"Annual report"},
+		},
+		{
+			name:          "json",
+			path:          "/api",
+			contentType:   "application/json",
+			body:          []byte(`{"name":"synapse","tags":["bot","gemini"]}`),
+			wantExtractor: extractorJSON,
+			wantContent:   []string{"{\n  \"name\": \"synapse\",\n  \"tags\": [\n    \"bot\","},
+		},
+		{
+			name:          "json api type",
+			path:          "/problem",
+			contentType:   "application/problem+json",
+			body:          []byte(`{"title":"Not found"}`),
+			wantExtractor: extractorJSON,
+			wantContent:   []string{`"title": "Not found"`},
+		},
+		{
+			name:          "plain text",
+			path:          "/robots.txt",
+			contentType:   "text/plain",
+			body:          []byte("User-agent: *\nDisallow: /private\n"),
+			wantExtractor: extractorText,
+			wantContent:   []string{"User-agent: *\nDisallow: /private"},
+		},
+		{
+			name:          "sniffed text",
+			path:          "/notes",
+			body:          []byte("just some notes"),
+			wantExtractor: extractorText,
+			wantContent:   []string{"just some notes"},
+		},
+		{
+			name:          "rss",
+			path:          "/feed",
+			contentType:   "application/rss+xml",
+			body:          []byte(rssFeed),
+			wantExtractor: extractorFeed,
+			check: func(t *testing.T, data *WebPageData) {
+				if data.Title != "Example Blog" || data.Description != "Posts about Go" || data.Language != "en-us" {
+					t.Errorf("unexpected feed metadata %+v", data)
+				}
+				want := []FeedItem{
+					{Title: "Go 1.23 released", Link: "https://blog.example.com/go-1-23", Published: "2024-08-13T10:00:00Z", Summary: "Range over functions is here."},
+					{Title: "Second post", Link: "https://blog.example.com/second", Published: "2024-08-01"},
+				}
+				if len(data.Items) != len(want) {
+					t.Fatalf("got %d items, want %d", len(data.Items), len(want))
+				}
+				for i := range want {
+					if data.Items[i] != want[i] {
+						t.Errorf("item %d = %+v, want %+v", i, data.Items[i], want[i])
+					}
+				}
+			},
+		},
+		{
+			name:          "atom served as xml",
+			path:          "/atom",
+			contentType:   "text/xml",
+			body:          []byte(atomFeed),
+			wantExtractor: extractorFeed,
+			check: func(t *testing.T, data *WebPageData) {
+				want := FeedItem{Title: "First entry", Link: "https://example.com/entries/1", Published: "2024-02-03T04:05:06Z", Summary: "Short summary"}
+				if data.Title != "Atom Example" || data.Language != "fr" || len(data.Items) != 1 || data.Items[0] != want {
+					t.Errorf("unexpected atom feed %+v", data)
+				}
+			},
+		},
+		{
+			name:          "plain xml",
+			path:          "/sitemap.xml",
+			contentType:   "application/xml",
+			body:          []byte(`<urlset><url><loc>https://example.com/</loc></url></urlset>`),
+			wantExtractor: extractorText,
+			wantContent:   []string{"<loc>https://example.com/</loc>"},
+		},
+	}
+
+	mux := http.NewServeMux()
+	for _, tt := range tests {
+		tt := tt
+		mux.HandleFunc(tt.path, func(w http.ResponseWriter, r *http.Request) {
+			// an empty Content-Type header stops net/http from sniffing one
+			w.Header()["Content-Type"] = []string{tt.contentType}
+			w.Write(tt.body)
+		})
+	}
+	server := httptest.NewServer(mux)
+	allowLoopback(t)
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := scrapeWebPage(context.Background(), server.URL+tt.path)
+
+			if data.Extractor != tt.wantExtractor {
+				t.Errorf("extractor = %q, want %q", data.Extractor, tt.wantExtractor)
+			}
+			for _, want := range tt.wantContent {
+				if !strings.Contains(data.Content, want) {
+					t.Errorf("content %q does not contain %q", data.Content, want)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, data)
+			}
+		})
+	}
+}
+
+func TestExtractPageErrors(t *testing.T) {
+	var data WebPageData
+	if err := extractPage("https://example.com/a.png", "image/png", []byte{0x89, 'P', 'N', 'G'}, &data); err == nil {
+		t.Error("expected an error for an image")
+	}
+
+	data = WebPageData{}
+	if err := extractPage("https://example.com/a.json", "application/json", []byte("{broken"), &data); err == nil || data.Extractor != extractorJSON {
+		t.Errorf("expected a json error, got %v with extractor %q", err, data.Extractor)
+	}
+}
+
+func TestPageContentTruncated(t *testing.T) {
+	text := strings.Repeat("é", maxPageContentChars)
+	got := pageContent(text)
+	if !strings.HasSuffix(got, "[content truncated]") || len(got) > maxPageContentChars+30 {
+		t.Errorf("content not truncated, length %d", len(got))
+	}
+}
+
+func TestScrapeWebPageAfterRedirect(t *testing.T) {
+	moved := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head>
+			<link rel="canonical" href="/docs/guide">
+			<meta property="og:image" content="img/cover.png">
+			</head><body><p>The guide moved to a new host, relative links resolve against it.</p></body></html>`))
+	}))
+	defer moved.Close()
+	old := httptest.NewServer(http.RedirectHandler(moved.URL+"/docs/guide?from=old", http.StatusMovedPermanently))
+	defer old.Close()
+	allowLoopback(t)
+
+	data := scrapeWebPage(context.Background(), old.URL+"/guide")
+	if data.Status != pageOK {
+		t.Fatalf("scrape failed: %+v", data)
+	}
+	if data.URL != moved.URL+"/docs/guide?from=old" || data.RequestedURL != old.URL+"/guide" {
+		t.Errorf("url = %q, requested = %q", data.URL, data.RequestedURL)
+	}
+	if data.CanonicalURL != moved.URL+"/docs/guide" || data.Image != moved.URL+"/docs/img/cover.png" {
+		t.Errorf("canonical = %q, image = %q, want them on the new host", data.CanonicalURL, data.Image)
+	}
+}
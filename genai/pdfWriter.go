@@ -0,0 +1,294 @@
+package genai
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A small PDF writer for create_file. It lays out plain text and simple
+// markdown (headings, bullet lists, code blocks) on A4 pages using the
+// standard Helvetica and Courier fonts, which PDF readers ship with, so no
+// font has to be embedded. Characters outside WinAnsiEncoding can't be
+// written, checkWinAnsi finds them so the model can pick another format.
+
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+)
+
+type pdfFont struct {
+	resource string
+	widths   []int // glyph widths of ' '..'~' in 1/1000 of the font size
+	fixed    int   // width of every glyph for monospaced fonts
+}
+
+var (
+	helvetica = &pdfFont{resource: "F1", widths: []int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}}
+	helveticaBold = &pdfFont{resource: "F2", widths: []int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}}
+	courier = &pdfFont{resource: "F3", fixed: 600}
+)
+
+func (f *pdfFont) width(text []byte, size float64) float64 {
+	total := 0
+	for _, c := range text {
+		switch {
+		case f.fixed > 0:
+			total += f.fixed
+		case c >= ' ' && c <= '~':
+			total += f.widths[c-' ']
+		default:
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// winAnsi maps the runes outside Latin-1 that WinAnsiEncoding has a code for
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, '‰': 0x89,
+	'‹': 0x8B, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96,
+	'—': 0x97, '™': 0x99, '›': 0x9B,
+}
+
+func winAnsiByte(r rune) (byte, bool) {
+	if r >= ' ' && r <= '~' || r >= 0xA0 && r <= 0xFF {
+		return byte(r), true
+	}
+	c, ok := winAnsi[r]
+	return c, ok
+}
+
+func encodeWinAnsi(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r == '\t' {
+			out = append(out, "    "...)
+		} else if c, ok := winAnsiByte(r); ok {
+			out = append(out, c)
+		} else {
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// checkWinAnsi returns an error naming the first few characters of content
+// the standard fonts can't show, like Cyrillic, CJK or emoji.
+func checkWinAnsi(content string) error {
+	var unsupported []string
+	seen := make(map[rune]bool)
+	for _, r := range content {
+		if r == '\t' || r == '\n' || r == '\r' || seen[r] {
+			continue
+		}
+		if _, ok := winAnsiByte(r); !ok {
+			seen[r] = true
+			unsupported = append(unsupported, fmt.Sprintf("%q", r))
+			if len(unsupported) == 5 {
+				break
+			}
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	return fmt.Errorf("pdf files only support Latin characters, the content has %s; create a md or txt file instead", strings.Join(unsupported, ", "))
+}
+
+type pdfLine struct {
+	font   *pdfFont
+	size   float64
+	indent float64
+	text   []byte
+	// extra space above the line
+	spaceBefore float64
+}
+
+type pdfLayout struct {
+	pages   [][]pdfLine
+	current []pdfLine
+	y       float64
+}
+
+func (l *pdfLayout) add(line pdfLine) {
+	height := line.size*1.4 + line.spaceBefore
+	if l.y-height < pdfMargin && len(l.current) > 0 {
+		l.pages = append(l.pages, l.current)
+		l.current = nil
+		l.y = pdfPageHeight - pdfMargin
+		line.spaceBefore = 0
+		height = line.size * 1.4
+	}
+	l.y -= height
+	l.current = append(l.current, line)
+}
+
+// addWrapped breaks text into lines that fit the page width.
+func (l *pdfLayout) addWrapped(text string, font *pdfFont, size, indent, spaceBefore float64) {
+	maxWidth := pdfPageWidth - 2*pdfMargin - indent
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		l.add(pdfLine{font: font, size: size, indent: indent, spaceBefore: spaceBefore})
+		return
+	}
+
+	var current []byte
+	for _, word := range words {
+		encoded := encodeWinAnsi(word)
+
+		candidate := encoded
+		if len(current) > 0 {
+			candidate = append(append(append([]byte{}, current...), ' '), encoded...)
+		}
+
+		if font.width(candidate, size) <= maxWidth || len(current) == 0 {
+			current = candidate
+			// a single word wider than the page is split by characters
+			for font.width(current, size) > maxWidth && len(current) > 1 {
+				cut := len(current) - 1
+				for cut > 1 && font.width(current[:cut], size) > maxWidth {
+					cut--
+				}
+				l.add(pdfLine{font: font, size: size, indent: indent, text: current[:cut], spaceBefore: spaceBefore})
+				spaceBefore = 0
+				current = append([]byte{}, current[cut:]...)
+			}
+			continue
+		}
+
+		l.add(pdfLine{font: font, size: size, indent: indent, text: current, spaceBefore: spaceBefore})
+		spaceBefore = 0
+		current = encoded
+	}
+	l.add(pdfLine{font: font, size: size, indent: indent, text: current, spaceBefore: spaceBefore})
+}
+
+var inlineMarkdown = strings.NewReplacer("**", "", "__", "", "~~", "", "`", "")
+
+// renderPDF lays out text (or markdown) as a PDF document.
+func renderPDF(content string) []byte {
+	layout := &pdfLayout{y: pdfPageHeight - pdfMargin}
+	inCode := false
+	paragraphGap := 0.0
+
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			paragraphGap = 6
+			continue
+		}
+
+		if inCode {
+			layout.add(pdfLine{font: courier, size: 9.5, indent: 12, text: encodeWinAnsi(line), spaceBefore: paragraphGap})
+			paragraphGap = 0
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			paragraphGap = 6
+		case strings.HasPrefix(trimmed, "# "):
+			layout.addWrapped(inlineMarkdown.Replace(trimmed[2:]), helveticaBold, 20, 0, 12)
+			paragraphGap = 4
+		case strings.HasPrefix(trimmed, "## "):
+			layout.addWrapped(inlineMarkdown.Replace(trimmed[3:]), helveticaBold, 16, 0, 10)
+			paragraphGap = 4
+		case strings.HasPrefix(trimmed, "### "), strings.HasPrefix(trimmed, "#### "):
+			layout.addWrapped(inlineMarkdown.Replace(strings.TrimLeft(trimmed, "# ")), helveticaBold, 13, 0, 8)
+			paragraphGap = 2
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			layout.addWrapped("• "+inlineMarkdown.Replace(trimmed[2:]), helvetica, 11, 14, paragraphGap)
+			paragraphGap = 0
+		default:
+			layout.addWrapped(inlineMarkdown.Replace(trimmed), helvetica, 11, 0, paragraphGap)
+			paragraphGap = 0
+		}
+	}
+
+	if len(layout.current) > 0 || len(layout.pages) == 0 {
+		layout.pages = append(layout.pages, layout.current)
+	}
+
+	return writePDF(layout.pages)
+}
+
+func pdfEscape(text []byte) []byte {
+	out := make([]byte, 0, len(text))
+	for _, c := range text {
+		if c == '\\' || c == '(' || c == ')' {
+			out = append(out, '\\')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func writePDF(pages [][]pdfLine) []byte {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	// 1 catalog, 2 page tree, 3-5 fonts, then a page and its content per page
+	const firstPageObject = 6
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPageObject+2*i))
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	for i, lines := range pages {
+		var stream bytes.Buffer
+		y := pdfPageHeight - pdfMargin
+		for _, line := range lines {
+			y -= line.size*1.4 + line.spaceBefore
+			if len(line.text) == 0 {
+				continue
+			}
+			fmt.Fprintf(&stream, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+				line.font.resource, line.size, pdfMargin+line.indent, y, pdfEscape(line.text))
+		}
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPageObject+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", stream.Len(), stream.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
@@ -26,3 +26,13 @@ const InitialSystemPrompt = "You are an AI chatbot assistant for Telegram named
 	"- **Explain tool usage**: If a tool is used, briefly explain why.\n" +
 	"- **Prioritize clarity**: Avoid overcomplicating responses. Provide clear and actionable information.\n\n" +
 	"Your goal is to provide helpful and well-formatted responses while being mindful of efficiency."
+
+const SummaryPrompt = "You maintain a running summary of a conversation between a user and Synapse, a Telegram assistant. " +
+	"Update the current summary with the new conversation turns below and reply with the updated summary only.\n" +
+	"- Keep facts about the user: their name, preferences, projects and anything they asked to be remembered.\n" +
+	"- Keep open questions, decisions and the results of searches or files that were created.\n" +
+	"- Drop greetings, formatting and details that won't matter later.\n" +
+	"- Write in the third person and stay under 300 words."
+
+const FinalTurnPrompt = "No more tools can be used for this message because %s. " +
+	"Answer the user now with what you have found so far, say what is missing and don't call any more functions."
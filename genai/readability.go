@@ -0,0 +1,523 @@
+package genai
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Main content extraction for scrapeWebPage, loosely following Mozilla's
+// Readability: boilerplate is removed, every paragraph adds a score to its
+// ancestors based on its length and commas, link heavy nodes are penalized
+// and the best scoring node, together with related siblings, is cleaned of
+// forms and converted to markdown.
+
+var (
+	// always boilerplate, whatever else the class says
+	boilerplateClass = regexp.MustCompile(`(?i)cookie|consent|gdpr|newsletter|popup|modal|subscribe`)
+
+	unlikelyCandidate = regexp.MustCompile(`(?i)-ad-|^ad-|advert|agegate|banner|breadcrumb|combx|comment|community|disqus|extra|footer|header|legends|menu|pager|pagination|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|supplemental`)
+	maybeCandidate    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+
+	positiveClass = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeClass = regexp.MustCompile(`(?i)-ad-|hidden|banner|combx|comment|com-|contact|foot|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+)
+
+const (
+	// forms aren't boilerplate up front, some sites wrap the whole page in
+	// one, see cleanForms
+	boilerplateTags  = "script, style, noscript, template, iframe, svg, canvas, object, embed, nav, footer, aside"
+	formControls     = "button, input, label, select, textarea"
+	boilerplateRoles = `[role="navigation"], [role="menu"], [role="menubar"], [role="complementary"], [role="dialog"], [role="alertdialog"], [role="banner"], [role="contentinfo"]`
+	hiddenElements   = `[hidden], [aria-hidden="true"], [style*="display:none"], [style*="display: none"]`
+)
+
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "dd": true,
+	"details": true, "div": true, "dl": true, "dt": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "tbody": true, "td": true, "th": true, "thead": true, "tr": true, "ul": true,
+}
+
+// extractMainContent returns the main content of doc as markdown. It
+// modifies doc, read anything else needed from it first.
+func extractMainContent(doc *goquery.Document) string {
+	body := doc.Find("body").First()
+	if body.Length() == 0 {
+		body = doc.Selection
+	}
+
+	removeBoilerplate(body)
+
+	scores := scoreParagraphs(body)
+	top, topScore := topCandidate(scores)
+	nodes := body.Nodes
+	if top != nil {
+		nodes = articleNodes(top, topScore, scores)
+	}
+
+	for _, n := range nodes {
+		cleanForms(goquery.NewDocumentFromNode(n).Selection)
+	}
+	return toMarkdown(nodes)
+}
+
+func removeBoilerplate(body *goquery.Selection) {
+	body.Find(boilerplateTags).Remove()
+	body.Find(boilerplateRoles).Remove()
+	body.Find(hiddenElements).Remove()
+
+	// a page header usually holds the logo and navigation, an article
+	// header the title
+	body.Find("header").Each(func(i int, s *goquery.Selection) {
+		if s.Closest("article, main").Length() == 0 {
+			s.Remove()
+		}
+	})
+
+	body.Find("*").Each(func(i int, s *goquery.Selection) {
+		if goquery.NodeName(s) == "article" || goquery.NodeName(s) == "main" {
+			return
+		}
+
+		match := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if strings.TrimSpace(match) == "" {
+			return
+		}
+
+		if boilerplateClass.MatchString(match) ||
+			unlikelyCandidate.MatchString(match) && !maybeCandidate.MatchString(match) {
+			s.Remove()
+		}
+	})
+}
+
+// cleanForms removes the forms in s that are little more than their
+// controls, like search and login boxes, and then every control. Forms
+// holding the article, as on ASP.NET WebForms pages, are kept. It's a
+// simpler take on Readability's _cleanConditionally.
+func cleanForms(s *goquery.Selection) {
+	s.Find("form").Each(func(i int, form *goquery.Selection) {
+		if !isContentForm(form) {
+			form.Remove()
+		}
+	})
+	s.Find(formControls).Remove()
+}
+
+func isContentForm(form *goquery.Selection) bool {
+	if classWeight(form.Nodes[0]) < 0 {
+		return false
+	}
+
+	controls := form.Find(formControls).Not(`[type="hidden"]`)
+	controlText := 0
+	controls.Each(func(i int, c *goquery.Selection) {
+		controlText += len(strings.TrimSpace(c.Text()))
+	})
+	if len(strings.TrimSpace(form.Text()))-controlText < 25 {
+		return false
+	}
+
+	paragraphs := form.Find("p").Length()
+	return controls.Length() <= paragraphs/3 && linkDensity(form) <= 0.2
+}
+
+// scoreParagraphs gives every ancestor of a paragraph a score, Readability
+// style: closer ancestors get more of it.
+func scoreParagraphs(body *goquery.Selection) map[*html.Node]float64 {
+	scores := make(map[*html.Node]float64)
+
+	body.Find("p, pre, td, blockquote, div, section").Each(func(i int, s *goquery.Selection) {
+		// containers only count when they hold nothing but text
+		if name := goquery.NodeName(s); (name == "div" || name == "section") && hasBlockChild(s.Nodes[0]) {
+			return
+		}
+
+		text := strings.TrimSpace(s.Text())
+		if len(text) < 25 {
+			return
+		}
+
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text)/100), 3)
+
+		level := 0
+		for n := s.Nodes[0].Parent; n != nil && level < 5; n = n.Parent {
+			if n.Type != html.ElementNode || n.Data == "html" {
+				break
+			}
+
+			if _, ok := scores[n]; !ok {
+				scores[n] = initialScore(n)
+			}
+
+			divider := 1.0
+			switch level {
+			case 0:
+			case 1:
+				divider = 2
+			default:
+				divider = float64(level * 3)
+			}
+			scores[n] += score / divider
+			level++
+		}
+	})
+
+	for n, score := range scores {
+		scores[n] = score * (1 - linkDensity(goquery.NewDocumentFromNode(n).Selection))
+	}
+
+	return scores
+}
+
+func initialScore(n *html.Node) float64 {
+	score := classWeight(n)
+	switch n.Data {
+	case "div", "article", "main":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+func classWeight(n *html.Node) float64 {
+	weight := 0.0
+	for _, attr := range n.Attr {
+		if attr.Key != "class" && attr.Key != "id" {
+			continue
+		}
+		if negativeClass.MatchString(attr.Val) {
+			weight -= 25
+		}
+		if positiveClass.MatchString(attr.Val) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// linkDensity is the share of s's text that is inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	textLength := len(strings.TrimSpace(s.Text()))
+	if textLength == 0 {
+		return 0
+	}
+
+	linkLength := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		linkLength += len(strings.TrimSpace(a.Text()))
+	})
+	return float64(linkLength) / float64(textLength)
+}
+
+func topCandidate(scores map[*html.Node]float64) (*html.Node, float64) {
+	var top *html.Node
+	topScore := 0.0
+	for n, score := range scores {
+		// ties go to the outermost node so the result doesn't depend on
+		// map order
+		if top == nil || score > topScore || score == topScore && contains(n, top) {
+			top, topScore = n, score
+		}
+	}
+	return top, topScore
+}
+
+func contains(ancestor, n *html.Node) bool {
+	for ; n != nil; n = n.Parent {
+		if n == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// articleNodes returns top and the siblings that look like they belong to
+// the same article, such as paragraphs split across several containers.
+func articleNodes(top *html.Node, topScore float64, scores map[*html.Node]float64) []*html.Node {
+	if top.Parent == nil {
+		return []*html.Node{top}
+	}
+
+	threshold := max(10, topScore*0.2)
+	topClass := attr(top, "class")
+
+	var nodes []*html.Node
+	for sibling := top.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+		if sibling == top {
+			nodes = append(nodes, sibling)
+			continue
+		}
+		if sibling.Type != html.ElementNode {
+			continue
+		}
+
+		bonus := 0.0
+		if topClass != "" && attr(sibling, "class") == topClass {
+			bonus = topScore * 0.2
+		}
+		if score, ok := scores[sibling]; ok && score+bonus >= threshold {
+			nodes = append(nodes, sibling)
+			continue
+		}
+
+		if sibling.Data == "p" {
+			s := goquery.NewDocumentFromNode(sibling).Selection
+			text := strings.TrimSpace(s.Text())
+			density := linkDensity(s)
+			if len(text) > 80 && density < 0.25 || len(text) > 0 && density == 0 && strings.Contains(text, ". ") {
+				nodes = append(nodes, sibling)
+			}
+		}
+	}
+	return nodes
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasBlockChild(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && blockTags[c.Data] {
+			return true
+		}
+	}
+	return false
+}
+
+// toMarkdown renders nodes as markdown blocks separated by blank lines.
+func toMarkdown(nodes []*html.Node) string {
+	w := &markdownWriter{}
+	for _, n := range nodes {
+		w.node(n, 0)
+	}
+	w.flush()
+	return strings.Join(w.blocks, "\n\n")
+}
+
+type markdownWriter struct {
+	blocks []string
+	inline strings.Builder
+}
+
+func (w *markdownWriter) add(block string) {
+	if block = strings.TrimRight(block, " \n"); strings.TrimSpace(block) != "" {
+		w.blocks = append(w.blocks, block)
+	}
+}
+
+// flush ends the paragraph of loose inline content, if any.
+func (w *markdownWriter) flush() {
+	w.add(cleanInline(w.inline.String()))
+	w.inline.Reset()
+}
+
+func (w *markdownWriter) node(n *html.Node, depth int) {
+	if n.Type == html.TextNode || n.Type == html.ElementNode && !blockTags[n.Data] {
+		w.inline.WriteString(inlineText(n))
+		return
+	}
+	if n.Type != html.ElementNode && n.Type != html.DocumentNode {
+		return
+	}
+
+	w.flush()
+
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		if text := cleanInline(inlineText(n)); text != "" {
+			w.add(strings.Repeat("#", level) + " " + strings.ReplaceAll(text, "\n", " "))
+		}
+	case "p", "dt", "dd", "figcaption":
+		if hasBlockChild(n) {
+			w.children(n, depth)
+			return
+		}
+		w.add(cleanInline(inlineText(n)))
+	case "ul", "ol":
+		w.add(listMarkdown(n, depth))
+	case "pre":
+		w.add("```\n" + strings.Trim(rawText(n), "\n") + "\n```")
+	case "blockquote":
+		quote := &markdownWriter{}
+		quote.children(n, depth)
+		quote.flush()
+		lines := strings.Split(strings.Join(quote.blocks, "\n\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		w.add(strings.Join(lines, "\n"))
+	case "table":
+		w.add(tableMarkdown(n))
+	case "hr":
+		w.add("---")
+	default:
+		w.children(n, depth)
+	}
+}
+
+func (w *markdownWriter) children(n *html.Node, depth int) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c, depth)
+	}
+	w.flush()
+}
+
+func listMarkdown(list *html.Node, depth int) string {
+	var lines []string
+	number := 1
+	for li := list.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if list.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+
+		// nested lists go on their own lines below the item text
+		var text strings.Builder
+		var nested []string
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "ul" || c.Data == "ol") {
+				nested = append(nested, listMarkdown(c, depth+1))
+				continue
+			}
+			text.WriteString(inlineText(c))
+			if c.Type == html.ElementNode && blockTags[c.Data] {
+				text.WriteString(" ")
+			}
+		}
+
+		item := strings.ReplaceAll(cleanInline(text.String()), "\n", " ")
+		if item != "" {
+			lines = append(lines, strings.Repeat("  ", depth)+marker+item)
+		}
+		lines = append(lines, nested...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func tableMarkdown(table *html.Node) string {
+	var rows []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.Data != "tr" {
+				walk(c)
+				continue
+			}
+
+			var cells []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+					text := strings.ReplaceAll(cleanInline(inlineText(cell)), "\n", " ")
+					cells = append(cells, strings.ReplaceAll(text, "|", `\|`))
+				}
+			}
+			if len(cells) == 0 {
+				continue
+			}
+
+			rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+			if len(rows) == 1 {
+				rows = append(rows, "|"+strings.Repeat(" --- |", len(cells)))
+			}
+		}
+	}
+	walk(table)
+	return strings.Join(rows, "\n")
+}
+
+// inlineText renders n as inline markdown, keeping line breaks.
+func inlineText(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		text := strings.Join(strings.Fields(n.Data), " ")
+		if text == "" {
+			if n.Data != "" {
+				return " "
+			}
+			return ""
+		}
+		if strings.TrimLeft(n.Data, " \t\r\n") != n.Data {
+			text = " " + text
+		}
+		if strings.TrimRight(n.Data, " \t\r\n") != n.Data {
+			text += " "
+		}
+		return text
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\n"
+	case "img":
+		return ""
+	case "code", "kbd", "samp":
+		if text := strings.TrimSpace(rawText(n)); text != "" {
+			return "`" + text + "`"
+		}
+		return ""
+	}
+
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(inlineText(c))
+	}
+	return sb.String()
+}
+
+var multipleSpaces = regexp.MustCompile(` {2,}`)
+
+func cleanInline(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(multipleSpaces.ReplaceAllString(line, " ")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rawText returns the text of n with its whitespace untouched.
+func rawText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && n.Data == "br" {
+		return "\n"
+	}
+
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(rawText(c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,89 @@
+package genai
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestExtractMainContent compares the extraction of every testdata/readability
+// page with the markdown next to it, run with -update to regenerate them.
+func TestExtractMainContent(t *testing.T) {
+	pages, err := filepath.Glob(filepath.Join("testdata", "readability", "*.html"))
+	if err != nil || len(pages) == 0 {
+		t.Fatalf("no test pages found: %v", err)
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(page)
+			if err != nil {
+				t.Fatalf("failed to read page: %v", err)
+			}
+
+			doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("failed to parse page: %v", err)
+			}
+
+			got := extractMainContent(doc) + "\n"
+
+			golden := strings.TrimSuffix(page, ".html") + ".md"
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatalf("failed to write golden file: %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("extraction mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestExtractMainContentNoCandidate(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><body>Just <b>one</b> line<br>and another</body></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := extractMainContent(doc); got != "Just one line\nand another" {
+		t.Errorf("extractMainContent() = %q", got)
+	}
+}
+
+func TestExtractMainContentForms(t *testing.T) {
+	page := `<html><body><div class="post">
+		<p>Forms holding nothing but their controls are dropped from the article, like the login box below, while the text around them stays.</p>
+		<form action="/login"><label>Email</label><input name="email"><label>Password</label><input type="password" name="password"><button>Sign in</button><p>Forgot your password? We can email you a reset link.</p></form>
+		<p>The same goes for search boxes and polls, which only make sense on the page itself and not in an extract of it.</p>
+	</div></body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := extractMainContent(doc)
+	for _, unwanted := range []string{"Email", "Sign in", "Forgot your password"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("login form kept: %q", got)
+		}
+	}
+	if !strings.Contains(got, "text around them stays") || !strings.Contains(got, "search boxes and polls") {
+		t.Errorf("article text lost: %q", got)
+	}
+}
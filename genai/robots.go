@@ -0,0 +1,343 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long a host's robots.txt is reused before it is fetched again
+	robotsTTL = 24 * time.Hour
+	// shorter for hosts whose robots.txt couldn't be fetched
+	robotsErrorTTL = 10 * time.Minute
+	// robots.txt files past this size are truncated, like Google does
+	maxRobotsSize = 500 << 10 // 500 KB
+	// crawl delays past this are capped, so one host can't stall the bot
+	maxCrawlDelay = time.Minute
+	// how often hosts that don't need remembering anymore are forgotten
+	politePruneInterval = time.Minute
+)
+
+var (
+	errRobotsDisallowed = errors.New("disallowed by robots.txt")
+	errRateLimited      = errors.New("rate limited")
+)
+
+// politeScraper is set when polite mode is on, scrapeWebPage then honors
+// robots.txt and waits between requests to the same host.
+var politeScraper *PoliteScraper
+
+// PoliteScraper checks URLs against their host's robots.txt and spaces out
+// requests to the same host, across all chats.
+type PoliteScraper struct {
+	userAgent string
+	delay     time.Duration
+
+	mu     sync.Mutex
+	robots map[string]*robotsEntry
+	next   map[string]time.Time // earliest time of the next request per host
+	pruned time.Time
+}
+
+type robotsEntry struct {
+	ready   chan struct{} // closed once rules is set
+	rules   *robotsRules
+	expires time.Time
+}
+
+// NewPoliteScraper creates a scraper identifying as userAgent that waits at
+// least delay between two requests to the same host, or the host's
+// Crawl-delay if that is longer.
+func NewPoliteScraper(userAgent string, delay time.Duration) *PoliteScraper {
+	return &PoliteScraper{
+		userAgent: userAgent,
+		delay:     delay,
+		robots:    make(map[string]*robotsEntry),
+		next:      make(map[string]time.Time),
+	}
+}
+
+// SetPoliteScraper turns on polite mode for extract_websites.
+func SetPoliteScraper(scraper *PoliteScraper) {
+	politeScraper = scraper
+}
+
+// Wait returns once link may be fetched. It fails with errRobotsDisallowed
+// or errRateLimited if link must be skipped.
+func (p *PoliteScraper) Wait(ctx context.Context, link string) error {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", link)
+	}
+
+	rules := p.rules(ctx, u)
+	if rules.unreachable {
+		return fmt.Errorf("%w: robots.txt could not be fetched, the site is treated as off limits", errRobotsDisallowed)
+	}
+	if !rules.allowed(u.EscapedPath(), u.RawQuery) {
+		return fmt.Errorf("%w: the site doesn't allow fetching this page", errRobotsDisallowed)
+	}
+
+	delay := max(p.delay, min(rules.crawlDelay, maxCrawlDelay))
+
+	p.mu.Lock()
+	now := time.Now()
+	at := now
+	if next, ok := p.next[u.Host]; ok && next.After(now) {
+		at = next
+	}
+	if deadline, ok := ctx.Deadline(); ok && at.After(deadline) {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: the site asks for %s between requests and the time for this request ran out", errRateLimited, delay)
+	}
+	p.next[u.Host] = at.Add(delay)
+	p.prune(now)
+	p.mu.Unlock()
+
+	if wait := at.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// rules returns the cached robots.txt rules for u's host, fetching them
+// once when several chats ask at the same time.
+func (p *PoliteScraper) rules(ctx context.Context, u *url.URL) *robotsRules {
+	p.mu.Lock()
+	entry, ok := p.robots[u.Host]
+	if ok {
+		select {
+		case <-entry.ready:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		p.robots[u.Host] = entry
+		p.prune(time.Now())
+		p.mu.Unlock()
+
+		rules, ttl := p.fetchRobots(ctx, u)
+		entry.rules = rules
+		entry.expires = time.Now().Add(ttl)
+		close(entry.ready)
+		return rules
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.rules
+	case <-ctx.Done():
+		return &robotsRules{unreachable: true}
+	}
+}
+
+// prune forgets expired robots.txt rules and request times in the past, so
+// the maps only hold the hosts visited recently. It runs at most every
+// politePruneInterval, mu must be held.
+func (p *PoliteScraper) prune(now time.Time) {
+	if now.Sub(p.pruned) < politePruneInterval {
+		return
+	}
+	p.pruned = now
+
+	for host, entry := range p.robots {
+		select {
+		case <-entry.ready:
+			if now.After(entry.expires) {
+				delete(p.robots, host)
+			}
+		default:
+			// still being fetched
+		}
+	}
+	for host, next := range p.next {
+		if !next.After(now) {
+			delete(p.next, host)
+		}
+	}
+}
+
+func (p *PoliteScraper) fetchRobots(ctx context.Context, u *url.URL) (*robotsRules, time.Duration) {
+	robotsURL := u.Scheme + "://" + u.Host + "/robots.txt"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", robotsURL, nil)
+	if err != nil {
+		return &robotsRules{unreachable: true}, robotsErrorTTL
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+
+	res, err := webClient.Do(req)
+	if err != nil {
+		logWithTime("[robots] Failed to fetch %s: %v", robotsURL, err)
+		return &robotsRules{unreachable: true}, 0
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 500:
+		// RFC 9309: a server error means the whole site is off limits
+		logWithTime("[robots] %s returned %d", robotsURL, res.StatusCode)
+		return &robotsRules{unreachable: true}, robotsErrorTTL
+	case res.StatusCode >= 400:
+		// no robots.txt, everything is allowed
+		return &robotsRules{}, robotsTTL
+	case res.StatusCode != http.StatusOK:
+		return &robotsRules{}, robotsErrorTTL
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxRobotsSize))
+	if err != nil {
+		return &robotsRules{unreachable: true}, robotsErrorTTL
+	}
+	return parseRobots(string(body), p.userAgent), robotsTTL
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsRules are the rules of the robots.txt group that applies to us.
+type robotsRules struct {
+	rules       []robotsRule
+	crawlDelay  time.Duration
+	unreachable bool
+}
+
+// parseRobots returns the rules of the groups naming userAgent's product
+// token, or of the "*" groups if none does.
+func parseRobots(content string, userAgent string) *robotsRules {
+	token, _, _ := strings.Cut(userAgent, "/")
+	token = strings.ToLower(strings.TrimSpace(token))
+
+	var specific, wildcard robotsRules
+	var foundSpecific bool
+
+	// the groups the current rules belong to
+	var agents []string
+	inRules := false
+
+	for _, line := range strings.Split(content, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+			continue
+		}
+
+		if key != "allow" && key != "disallow" && key != "crawl-delay" {
+			continue
+		}
+		inRules = true
+
+		for _, agent := range agents {
+			var group *robotsRules
+			switch agent {
+			case token:
+				group = &specific
+				foundSpecific = true
+			case "*":
+				group = &wildcard
+			default:
+				continue
+			}
+
+			switch key {
+			case "allow", "disallow":
+				// an empty Disallow allows everything
+				if value != "" {
+					group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+				}
+			case "crawl-delay":
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					group.crawlDelay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	if foundSpecific {
+		return &specific
+	}
+	return &wildcard
+}
+
+// allowed applies the most specific matching rule, Allow wins a tie.
+func (r *robotsRules) allowed(path, rawQuery string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if rawQuery != "" {
+		path += "?" + rawQuery
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allow, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch matches path against a robots.txt pattern, where "*" is any
+// sequence of characters and a trailing "$" anchors the end.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+
+	return !anchored || rest == ""
+}
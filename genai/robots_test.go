@@ -0,0 +1,201 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const robotsTxt = `# example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public.html
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: OtherBot
+User-agent: SynapseBot
+Disallow: /no-bots
+Allow: /no-bots/except
+Crawl-delay: 0.5
+
+User-agent: BadBot
+Disallow: /
+`
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		path      string
+		want      bool
+	}{
+		{"SomeBot/2.0", "/", true},
+		{"SomeBot/2.0", "/private/secret", false},
+		{"SomeBot/2.0", "/private/public.html", true},
+		{"SomeBot/2.0", "/files/report.pdf", false},
+		{"SomeBot/2.0", "/files/report.pdf?download=1", true},
+		{"SynapseBot/1.0", "/private/secret", true},
+		{"SynapseBot/1.0", "/no-bots", false},
+		{"SynapseBot/1.0", "/no-bots/page", false},
+		{"SynapseBot/1.0", "/no-bots/except/page", true},
+		{"synapsebot", "/no-bots", false},
+		{"BadBot", "/anything", false},
+		{"BadBot", "/robots.txt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAgent+tt.path, func(t *testing.T) {
+			rules := parseRobots(robotsTxt, tt.userAgent)
+			path, query, _ := strings.Cut(tt.path, "?")
+			if got := rules.allowed(path, query); got != tt.want {
+				t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if got := parseRobots(robotsTxt, "SomeBot/2.0").crawlDelay; got != 2*time.Second {
+		t.Errorf("crawl delay for the * group = %s, want 2s", got)
+	}
+	if got := parseRobots(robotsTxt, "SynapseBot/1.0").crawlDelay; got != 500*time.Millisecond {
+		t.Errorf("crawl delay for SynapseBot = %s, want 500ms", got)
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish*", "/fishheads/yummy.html", true},
+		{"/*.php", "/folder/filename.php?parameters", true},
+		{"/*.php", "/windows.PHP", false},
+		{"/*.php$", "/filename.php", true},
+		{"/*.php$", "/filename.php/", false},
+		{"/fish*.php", "/fishheads/catfish.php?parameters", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"/exact$", "/exact", true},
+		{"/exact$", "/exactly", false},
+	}
+
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestScrapeWebPagePolite(t *testing.T) {
+	var robotsRequests atomic.Int64
+	var gotUserAgent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsRequests.Add(1)
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+		default:
+			gotUserAgent.Store(r.Header.Get("User-Agent"))
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, "page %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	allowLoopback(t)
+
+	SetPoliteScraper(NewPoliteScraper("SynapseBot/1.0", 200*time.Millisecond))
+	defer SetPoliteScraper(nil)
+
+	data := scrapeWebPage(context.Background(), server.URL+"/private/page")
+	if data.Status != pageSkipped || data.ErrorKind != errKindRobots || data.Content != "" {
+		t.Fatalf("disallowed page was fetched: %+v", data)
+	}
+
+	start := time.Now()
+	for _, path := range []string{"/a", "/b"} {
+		data := scrapeWebPage(context.Background(), server.URL+path)
+		if data.Status != pageOK || data.Content == "" {
+			t.Fatalf("allowed page %s wasn't fetched: %+v", path, data)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("two requests to one host took %s, want at least the 200ms delay", elapsed)
+	}
+
+	if got := gotUserAgent.Load(); got != "SynapseBot/1.0" {
+		t.Errorf("User-Agent = %v, want the bot's", got)
+	}
+	if got := robotsRequests.Load(); got != 1 {
+		t.Errorf("robots.txt fetched %d times, want 1", got)
+	}
+}
+
+func TestPoliteScraperDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nCrawl-delay: 30\n")
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+	allowLoopback(t)
+
+	scraper := NewPoliteScraper("SynapseBot/1.0", 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := scraper.Wait(ctx, server.URL+"/first"); err != nil {
+		t.Fatalf("first request was held back: %v", err)
+	}
+	if err := scraper.Wait(ctx, server.URL+"/second"); !errors.Is(err, errRateLimited) {
+		t.Fatalf("second request within the crawl delay wasn't skipped: %v", err)
+	}
+}
+
+func TestPoliteScraperForgetsOldHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+	allowLoopback(t)
+
+	scraper := NewPoliteScraper("SynapseBot/1.0", 0)
+	done := make(chan struct{})
+	close(done)
+	now := time.Now()
+	scraper.robots["expired.example.com"] = &robotsEntry{ready: done, rules: &robotsRules{}, expires: now.Add(-time.Second)}
+	scraper.robots["fresh.example.com"] = &robotsEntry{ready: done, rules: &robotsRules{}, expires: now.Add(time.Hour)}
+	scraper.robots["fetching.example.com"] = &robotsEntry{ready: make(chan struct{})}
+	scraper.next["past.example.com"] = now.Add(-time.Second)
+	scraper.next["soon.example.com"] = now.Add(time.Minute)
+
+	if err := scraper.Wait(context.Background(), server.URL+"/page"); err != nil {
+		t.Fatal(err)
+	}
+
+	scraper.mu.Lock()
+	defer scraper.mu.Unlock()
+	for _, host := range []string{"fresh.example.com", "fetching.example.com"} {
+		if _, ok := scraper.robots[host]; !ok {
+			t.Errorf("robots.txt of %s forgotten", host)
+		}
+	}
+	if _, ok := scraper.robots["expired.example.com"]; ok {
+		t.Error("expired robots.txt kept")
+	}
+	if _, ok := scraper.next["past.example.com"]; ok {
+		t.Error("request time in the past kept")
+	}
+	if _, ok := scraper.next["soon.example.com"]; !ok {
+		t.Error("upcoming request time forgotten")
+	}
+}
@@ -0,0 +1,102 @@
+package genai
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// every chat gets its own directory under filesDir, tools can only touch
+// files in the directory of the chat they run for
+const filesDir = "synapse_files"
+
+func chatIDFromContext(ctx context.Context) (int, bool) {
+	chatID, ok := ctx.Value("chatId").(int)
+	return chatID, ok
+}
+
+func chatFileDir(chatID int) (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %v", err)
+	}
+
+	return filepath.Join(dir, filesDir, strconv.Itoa(chatID)), nil
+}
+
+// sanitizeFileName turns a user or model supplied name into a plain file
+// name, anything that looks like a path is rejected.
+func sanitizeFileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+
+	if strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("invalid file name %q: paths are not allowed", name)
+	}
+
+	return name, nil
+}
+
+// sandboxPath resolves name inside the chat's directory, creating the
+// directory if needed.
+func sandboxPath(ctx context.Context, name string) (string, error) {
+	chatID, ok := chatIDFromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("no chat associated with this request")
+	}
+
+	name, err := sanitizeFileName(name)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := chatFileDir(chatID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+
+	return path, nil
+}
+
+// saveUpload stores a file the user sent in the chat's directory and returns
+// the name read_file knows it by.
+func saveUpload(ctx context.Context, name string, data []byte) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = "upload"
+	}
+
+	path, err := sandboxPath(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save file: %v", err)
+	}
+
+	return name, nil
+}
+
+// uploadNote tells the model about a file saved with saveUpload at saved.
+// The note outlives the file in the history, so it says until when the
+// file can be read.
+func uploadNote(name string, saved time.Time) string {
+	expires := saved.Add(fileLifetime).UTC().Format("15:04 UTC on Jan 2")
+	return fmt.Sprintf("[The user uploaded %q, it can be read with read_file until about %s, uploads are deleted after that]", name, expires)
+}
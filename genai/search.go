@@ -1,10 +1,11 @@
 package genai
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,9 +15,6 @@ import (
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/PuerkitoBio/goquery"
-	"github.com/google/generative-ai-go/genai"
 )
 
 type SearchResult struct {
@@ -26,44 +24,81 @@ type SearchResult struct {
 	Position int    `json:"position,omitempty"`
 }
 
+// WebPageData is a scraped page. URL is where the page was found after
+// redirects, RequestedURL the link asked for when it redirected elsewhere.
 type WebPageData struct {
-	URL         string `json:"url"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Date        string `json:"date"`
-	Author      string `json:"author"`
-	Content     string `json:"content"`
+	URL          string       `json:"url"`
+	RequestedURL string       `json:"requested_url,omitempty"`
+	CanonicalURL string       `json:"canonical_url,omitempty"`
+	Type         string       `json:"type,omitempty"`
+	Title        string       `json:"title"`
+	Description  string       `json:"description"`
+	Author       string       `json:"author"`
+	SiteName     string       `json:"site_name,omitempty"`
+	Language     string       `json:"language,omitempty"`
+	Published    string       `json:"published,omitempty"`
+	Modified     string       `json:"modified,omitempty"`
+	Image        string       `json:"image,omitempty"`
+	Product      *ProductData `json:"product,omitempty"`
+	Items        []FeedItem   `json:"items,omitempty"`
+	Extractor    string       `json:"extractor,omitempty"`
+	Status       string       `json:"status"`
+	HTTPStatus   int          `json:"http_status,omitempty"`
+	ErrorKind    string       `json:"error_kind,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	ElapsedMS    int64        `json:"elapsed_ms"`
+	Content      string       `json:"content"`
 }
 
+// Status of a scraped page, so the model can tell an empty page from one
+// that failed.
+const (
+	pageOK      = "ok"
+	pageEmpty   = "empty"
+	pageSkipped = "skipped"
+	pageError   = "error"
+)
+
+// ErrorKind of a page that was skipped or failed.
+const (
+	errKindInvalidURL  = "invalid_url"
+	errKindBlocked     = "blocked"
+	errKindRobots      = "robots"
+	errKindRateLimited = "rate_limited"
+	errKindTimeout     = "timeout"
+	errKindCanceled    = "canceled"
+	errKindDNS         = "dns"
+	errKindTLS         = "tls"
+	errKindConnection  = "connection"
+	errKindHTTPStatus  = "http_status"
+	errKindRead        = "read"
+	errKindExtract     = "extract"
+	errKindInternal    = "internal"
+)
+
 const maxConcurrentScrapers = 4
 
+// number of search results web_search extracts when extract_websites is set
+var extractTopN = 5
+
+// SetExtractTopN sets how many search results are extracted per search.
+func SetExtractTopN(n int) {
+	extractTopN = n
+}
+
 var (
 	webClient = &http.Client{
 		Transport: &http.Transport{
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 100,
 			IdleConnTimeout:     30 * time.Second,
-			DialContext: (&net.Dialer{
+			DialContext: guardedDialContext(&net.Dialer{
 				Timeout:   3 * time.Second,
 				KeepAlive: 30 * time.Second,
-			}).DialContext,
-		},
-		Timeout: 10 * time.Second,
-	}
-
-	contentSelectors = []string{
-		"article", "main", "#content",
-		".content", ".article-content",
-		".post-content", ".content p",
-		"[role='main']", "[role='article']",
-		"p", "h1", "h2", "h3", "h4", "h5",
-		"ul:not(nav ul)", "ol:not(nav ol)",
-	}
-
-	searchResultPool = sync.Pool{
-		New: func() any {
-			return make([]SearchResult, 0, 10)
+			}),
 		},
+		CheckRedirect: checkRedirect,
+		Timeout:       10 * time.Second,
 	}
 
 	bufPool = sync.Pool{
@@ -77,78 +112,50 @@ var (
 			return &WebPageData{}
 		},
 	}
-
-	contentBuilderPool = sync.Pool{
-		New: func() any {
-			return &strings.Builder{}
-		},
-	}
 )
 
-func webSearch(ctx context.Context, funCall genai.FunctionCall) (string, error) {
-	query, ok := funCall.Args["query"].(string)
-	if !ok || strings.TrimSpace(query) == "" {
-		return "", fmt.Errorf("invalid or missing query argument")
-	}
-
-	extractWebsites, ok := funCall.Args["extract_websites"].(bool)
-	if !ok {
-		return "", fmt.Errorf("invalid or missing extract_websites argument")
-	}
-
-	url := fmt.Sprintf("https://google.com/search?q=%s&gl&hl=en", url.QueryEscape(query))
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
-	}
-
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.54 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+// scrapeTimeout bounds fetching all pages of one scrapeWebsites call
+const scrapeTimeout = 10 * time.Second
+
+func init() {
+	// every search provider may be tried in turn before the results are scraped
+	Register(WithTimeout(NewTool("web_search",
+		"Perform a web search and optionally extract data from top search results.",
+		webSearch), func() time.Duration {
+		return time.Duration(len(searchProviders))*webClient.Timeout + scrapeTimeout + 5*time.Second
+	}))
+	Register(WithTimeout(NewTool("extract_websites",
+		"Retrieve and extract relevant data from provided website links to address user queries effectively. Works for html pages, PDFs, JSON, plain text and RSS/Atom feeds. Every page comes with its canonical url, author, site name and published and modified dates, use them when citing sources. Each page has a status (ok, empty, skipped or error); for skipped and failed pages error_kind, error and http_status say why, tell the user when a source couldn't be read.",
+		extractWebPagesContent), func() time.Duration {
+		return scrapeTimeout + 5*time.Second
+	}))
+}
 
-	res, err := webClient.Do(req)
+type webSearchArgs struct {
+	Query           string `json:"query" description:"The search query to execute on the web (returns top search results)."`
+	ExtractWebsites bool   `json:"extract_websites" description:"If true, data will be extracted from each top search result."`
+}
 
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch results for query '%s' : %w", query, err)
-	}
-	defer res.Body.Close()
+func webSearch(ctx context.Context, args webSearchArgs) (string, error) {
+	query := args.Query
 
-	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-200 status code: %d", res.StatusCode)
+	results, provider, err := search(ctx, query)
+	if errors.Is(err, errNoResults) {
+		logWithTime("[webSearch] %v", err)
+		return noResultsResponse(query)
 	}
-
-	bodyReader := bufio.NewReader(res.Body)
-
-	doc, err := goquery.NewDocumentFromReader(bodyReader)
 	if err != nil {
-		return "", fmt.Errorf("error parsing document: %w", err)
+		return "", fmt.Errorf("failed to fetch results for query '%s' : %w", query, err)
 	}
 
-	results := searchResultPool.Get().([]SearchResult)
-	results = results[:0]
-	defer searchResultPool.Put(results)
-	doc.Find("div.g").Each(func(i int, s *goquery.Selection) {
-		title := strings.TrimSpace(s.Find("h3").First().Text())
-		link, exists := s.Find("a").First().Attr("href")
-		if !exists {
-			return
-		}
-		link = strings.TrimSpace(link)
-		snippet := strings.TrimSpace(s.Find(".VwiC3b").First().Text())
-
-		if title != "" && link != "" {
-			results = append(results, SearchResult{
-				Title:    title,
-				Link:     link,
-				Snippet:  snippet,
-				Position: len(results) + 1,
-			})
-		}
-	})
+	logWithTime("[webSearch] %d results from %s", len(results), provider)
 
-	if extractWebsites {
+	if args.ExtractWebsites {
 		var links []string
-		for _, v := range results[:5] {
+		for _, v := range dedupeByDomain(results) {
+			if len(links) == extractTopN {
+				break
+			}
 			links = append(links, v.Link)
 		}
 
@@ -166,79 +173,81 @@ func webSearch(ctx context.Context, funCall genai.FunctionCall) (string, error)
 	return buf.String(), nil
 }
 
-func scrapeWebsites(ctx context.Context, links []string) string {
+// noResultsResponse tells the model nothing was found in a form it can act
+// on, instead of failing the tool call.
+func noResultsResponse(query string) (string, error) {
+	response, err := json.Marshal(map[string]string{
+		"error":   "no_results",
+		"query":   query,
+		"message": "The search returned no results. Try a shorter or differently worded query.",
+	})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling results: %w", err)
+	}
+	return string(response), nil
+}
 
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+// dedupeByDomain keeps the first result of every domain so extraction
+// doesn't spend its budget on several pages of the same site.
+func dedupeByDomain(results []SearchResult) []SearchResult {
+	seen := make(map[string]struct{}, len(results))
+	deduped := make([]SearchResult, 0, len(results))
+	for _, result := range results {
+		u, err := url.Parse(result.Link)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		domain := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+		if _, ok := seen[domain]; ok {
+			continue
+		}
+		seen[domain] = struct{}{}
+		deduped = append(deduped, result)
+	}
+	return deduped
+}
+
+// scrapeWebsites returns every link's page in order, including the ones
+// that failed or weren't reached before the timeout.
+func scrapeWebsites(ctx context.Context, links []string) string {
+	ctx, cancel := context.WithTimeout(ctx, scrapeTimeout)
 	defer cancel()
-	resultChan := make(chan *WebPageData, len(links))
-	errChan := make(chan error, len(links))
-	results := make([]WebPageData, 0, len(links))
+
+	results := make([]WebPageData, len(links))
 	semaphore := make(chan struct{}, maxConcurrentScrapers)
 	var wg sync.WaitGroup
 
-	for _, link := range links {
-		wg.Add(1)
+	for i, link := range links {
 		select {
 		case semaphore <- struct{}{}:
-			go func(link string) {
-				defer wg.Done()
-				defer func() { <-semaphore }()
-				defer func() {
-					if r := recover(); r != nil {
-						logWithTime("Recovered from panic processing %s: %v", link, r)
-					}
-				}()
-
-				select {
-				case <-ctx.Done():
-					logWithTime("[scrapeWebsites] Context canceled or timeout reached for %s", link)
-					errChan <- ctx.Err()
-					return
-				default:
-					if data := scrapeWebPage(ctx, link); data != nil {
-						resultChan <- data
-					} else {
-						errChan <- fmt.Errorf("failed to scrape %s", link)
-					}
-
-				}
-
-			}(link)
-
 		case <-ctx.Done():
-			log.Println("[scrapeWebsites] Context canceled or timeout reached")
-			wg.Done()
-			return "{}"
+			logWithTime("[scrapeWebsites] Context canceled or timeout reached before %s", link)
+			results[i] = WebPageData{URL: link}
+			results[i].fail(errKindFor(ctx.Err()), fmt.Errorf("not fetched: %w", ctx.Err()))
+			continue
 		}
-	}
 
-	go func() {
-		wg.Wait()
-		close(resultChan)
-		close(errChan)
-	}()
+		wg.Add(1)
+		go func(i int, link string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer func() {
+				if r := recover(); r != nil {
+					logWithTime("Recovered from panic processing %s: %v", link, r)
+					results[i] = WebPageData{URL: link}
+					results[i].fail(errKindInternal, fmt.Errorf("internal error"))
+				}
+			}()
 
-	for {
-		select {
-		case result, ok := <-resultChan:
-			if !ok {
-				log.Println("[scrapeWebsites] resultChan closed")
-				goto DONE
-			}
-			if results != nil {
-				results = append(results, *result)
-			}
-		case <-ctx.Done():
-			log.Println("[scrapeWebsites] Context canceled or timeout reached")
-			return "{}"
-		}
+			// every request uses ctx, so they all return soon after the timeout
+			data := scrapeWebPage(ctx, link)
+			results[i] = *data
+			webPageDataPool.Put(data)
+		}(i, link)
 	}
 
-DONE:
-
-	for err := range errChan {
-		log.Println("[scrapeWebsites] Error encountered:", err)
-	}
+	wg.Wait()
 
 	resultsByte, err := json.Marshal(results)
 	if err != nil {
@@ -272,132 +281,139 @@ func trimContent(content string) string {
 	return sb.String()
 }
 
-func removeDuplicateContent(content string) string {
-	if len(content) == 0 {
-		return ""
-	}
-
-	seen := make(map[string]struct{})
-	builder := strings.Builder{}
-	builder.Grow(len(content))
-
-	isFirst := true
-	for _, line := range strings.Split(content, "\n") {
-		if _, exits := seen[line]; !exits {
-			seen[line] = struct{}{}
-			if !isFirst {
-				builder.WriteByte('\n')
-			}
-			builder.WriteString(line)
-			isFirst = false
-		}
-	}
-
-	return builder.String()
-}
-
-func processContent(content string, resultChan chan<- string) {
-	processed := removeDuplicateContent(content)
-	resultChan <- processed
-}
-
 func scrapeWebPage(ctx context.Context, websiteLink string) *WebPageData {
 	websiteContent := webPageDataPool.Get().(*WebPageData)
 	*websiteContent = WebPageData{
 		URL: websiteLink,
 	}
 
+	start := time.Now()
+	defer func() {
+		websiteContent.ElapsedMS = time.Since(start).Milliseconds()
+	}()
+
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(timeoutCtx, "GET", websiteLink, nil)
+	req, err := http.NewRequestWithContext(withCacheTTL(timeoutCtx, pageCacheTTL), "GET", websiteLink, nil)
 	if err != nil {
 		logWithTime("[scrapeWebPage] Error creating request: %v", err)
+		websiteContent.fail(errKindInvalidURL, err)
+		return websiteContent
+	}
+
+	if err := urlPolicy.CheckURL(req.URL); err != nil {
+		logWithTime("[scrapeWebPage] Skipping %s: %v", websiteLink, err)
+		websiteContent.fail(errKindBlocked, err)
 		return websiteContent
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.54 Safari/537.36")
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
+	userAgent := browserUserAgent
+	if politeScraper != nil {
+		if err := politeScraper.Wait(timeoutCtx, websiteLink); err != nil {
+			logWithTime("[scrapeWebPage] Skipping %s: %v", websiteLink, err)
+			websiteContent.fail(errKindFor(err), err)
+			return websiteContent
+		}
+		userAgent = politeScraper.userAgent
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,application/pdf,application/json,text/plain;q=0.8,*/*;q=0.5")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
 
 	res, err := webClient.Do(req)
 	if err != nil {
-		fmt.Println("Failed to send req: ", err)
+		logWithTime("[scrapeWebPage] Failed to fetch %s: %v", websiteLink, err)
+		websiteContent.fail(errKindFor(err), err)
 		return websiteContent
 	}
 	defer res.Body.Close()
 
+	// relative links in the page are relative to where it redirected to
+	pageURL := res.Request.URL.String()
+	if pageURL != websiteLink {
+		websiteContent.URL = pageURL
+		websiteContent.RequestedURL = websiteLink
+	}
+
+	websiteContent.HTTPStatus = res.StatusCode
 	if res.StatusCode != http.StatusOK {
 		logWithTime("[scrapeWebPage] Received non-200 status code: %d", res.StatusCode)
+		websiteContent.fail(errKindHTTPStatus, fmt.Errorf("server responded with %s", res.Status))
 		return websiteContent
 	}
 
-	// bodyBuf := bufPool.Get().(*bytes.Buffer)
-	// bodyBuf.Reset()
-	// defer bufPool.Put(bodyBuf)
-	//
-	// _, err = io.CopyN(bodyBuf, res.Body, 10<<20) // 10 MB
-	// if err != nil && err != io.EOF {
-	// 	logWithTime("error reading response body: %v", err)
-	// 	return websiteContent
-	// }
-
-	reader := io.LimitReader(res.Body, 10<<20) // 10 MB
-
-	doc, err := goquery.NewDocumentFromReader(reader)
+	body, err := io.ReadAll(io.LimitReader(res.Body, 10<<20)) // 10 MB
 	if err != nil {
-		logWithTime("[scrapeWebPage] Error parsing document: %v", err)
-
+		logWithTime("[scrapeWebPage] Error reading response body: %v", err)
+		kind := errKindFor(err)
+		if kind == errKindConnection {
+			kind = errKindRead
+		}
+		websiteContent.fail(kind, err)
 		return websiteContent
 	}
 
-	builder := contentBuilderPool.Get().(*strings.Builder)
-	builder.Reset()
-	defer contentBuilderPool.Put(builder)
-
-	websiteContent.Title = doc.Find("title").Text()
-	websiteContent.Description = trimContent(doc.Find(`meta[name="description"]`).AttrOr("content", ""))
-	websiteContent.Date = doc.Find("time").AttrOr("datetime", "")
-	websiteContent.Author = trimContent(doc.Find(`meta[name="author"]`).AttrOr("content", ""))
-
-	for _, selector := range contentSelectors {
-		doc.Find(selector).Each(func(i int, s *goquery.Selection) {
-			text := strings.TrimSpace(s.Text())
-			if text != "" {
-				builder.WriteString(trimContent(text))
-				builder.WriteString("\n")
-			}
-		})
-	}
-
-	contentChan := make(chan string)
-	go processContent(builder.String(), contentChan)
-
-	select {
-	case processContent := <-contentChan:
-		websiteContent.Content = processContent
-	case <-ctx.Done():
-		logWithTime("[scrapeWebPage] Context canceled or timeout reached for website: %s", websiteLink)
+	if err := extractPage(pageURL, res.Header.Get("Content-Type"), body, websiteContent); err != nil {
+		logWithTime("[scrapeWebPage] Error extracting %s: %v", websiteLink, err)
+		websiteContent.fail(errKindExtract, err)
 		return websiteContent
 	}
 
+	if strings.TrimSpace(websiteContent.Content) == "" && len(websiteContent.Items) == 0 {
+		websiteContent.Status = pageEmpty
+	} else {
+		websiteContent.Status = pageOK
+	}
 	return websiteContent
 }
 
-func extractWebPagesContent(ctx context.Context, funCall genai.FunctionCall) (string, error) {
-	rawLinks, ok := funCall.Args["links"].([]any)
-	if !ok {
-		return "", fmt.Errorf("invalid or missing links argument")
+// fail records why the page couldn't be scraped, pages left out on purpose
+// are skipped rather than failed.
+func (d *WebPageData) fail(kind string, err error) {
+	d.Status = pageError
+	switch kind {
+	case errKindBlocked, errKindRobots, errKindRateLimited:
+		d.Status = pageSkipped
 	}
+	d.ErrorKind = kind
+	d.Error = err.Error()
+}
 
-	links := make([]string, len(rawLinks))
-	for i, link := range rawLinks {
-		strLink, ok := link.(string)
-		if !ok {
-			return "", fmt.Errorf("invalid link at index %d, expected string", i)
-		}
-		links[i] = strLink
+// errKindFor classifies a request error for the model.
+func errKindFor(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+
+	switch {
+	case errors.Is(err, errBlockedURL):
+		return errKindBlocked
+	case errors.Is(err, errRobotsDisallowed):
+		return errKindRobots
+	case errors.Is(err, errRateLimited):
+		return errKindRateLimited
+	case errors.Is(err, context.DeadlineExceeded):
+		return errKindTimeout
+	case errors.Is(err, context.Canceled):
+		return errKindCanceled
+	case errors.As(err, &dnsErr):
+		return errKindDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return errKindTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errKindTimeout
+	default:
+		return errKindConnection
 	}
+}
+
+type extractWebsitesArgs struct {
+	Links []string `json:"links" description:"An array of links from which data needs to be extracted."`
+}
 
-	return scrapeWebsites(ctx, links), nil
+func extractWebPagesContent(ctx context.Context, args extractWebsitesArgs) (string, error) {
+	return scrapeWebsites(ctx, args.Links), nil
 }
@@ -0,0 +1,387 @@
+package genai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SearchProvider runs a web search for the web_search tool.
+type SearchProvider interface {
+	Name() string
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+}
+
+// providers are tried in order until one returns results
+var searchProviders = []SearchProvider{
+	NewGoogleSearch(),
+	NewDuckDuckGoSearch(),
+}
+
+// SetSearchProviders sets the providers web_search uses, in fallback order.
+func SetSearchProviders(providers ...SearchProvider) {
+	searchProviders = providers
+}
+
+// NewSearchProvider builds a provider from its config name. SearXNG needs
+// the instance URL, Brave and Bing an API key, passed as option.
+func NewSearchProvider(name string, option string) (SearchProvider, error) {
+	switch strings.ToLower(name) {
+	case "google":
+		return NewGoogleSearch(), nil
+	case "duckduckgo", "ddg":
+		return NewDuckDuckGoSearch(), nil
+	case "searxng":
+		if option == "" {
+			return nil, fmt.Errorf("searxng needs an instance URL")
+		}
+		return NewSearXNGSearch(option), nil
+	case "brave":
+		if option == "" {
+			return nil, fmt.Errorf("brave needs an API key")
+		}
+		return NewBraveSearch(option), nil
+	case "bing":
+		if option == "" {
+			return nil, fmt.Errorf("bing needs an API key")
+		}
+		return NewBingSearch(option), nil
+	default:
+		return nil, fmt.Errorf("unknown search provider %q", name)
+	}
+}
+
+const browserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.54 Safari/537.36"
+
+// errNoResults is returned by search when no provider found anything and at
+// least one of them worked, so the query rather than the providers is the
+// problem. The errors of the others are attached.
+var errNoResults = errors.New("no results found")
+
+// search tries every provider in order and returns the first non-empty
+// results together with the provider that found them.
+func search(ctx context.Context, query string) ([]SearchResult, string, error) {
+	var errs []string
+	answered := false
+	for _, provider := range searchProviders {
+		results, err := provider.Search(ctx, query)
+		if err != nil {
+			logWithTime("[search] %s failed: %v", provider.Name(), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", provider.Name(), err))
+			continue
+		}
+		if len(results) == 0 {
+			logWithTime("[search] %s returned no results", provider.Name())
+			errs = append(errs, fmt.Sprintf("%s: no results", provider.Name()))
+			answered = true
+			continue
+		}
+		return results, provider.Name(), nil
+	}
+
+	if answered {
+		return nil, "", fmt.Errorf("%w (%s)", errNoResults, strings.Join(errs, "; "))
+	}
+	return nil, "", fmt.Errorf("all search providers failed: %s", strings.Join(errs, "; "))
+}
+
+func getSearchPage(ctx context.Context, pageURL string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(withCacheTTL(ctx, searchCacheTTL), "GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := webClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status code: %d", res.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, 5<<20))
+}
+
+var browserHeaders = map[string]string{
+	"User-Agent":      browserUserAgent,
+	"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8",
+	"Accept-Language": "en-US,en;q=0.5",
+}
+
+// GoogleSearch scrapes the Google results page. It breaks whenever Google
+// changes its markup, prefer an API provider when one is configured.
+type GoogleSearch struct {
+	BaseURL string
+}
+
+func NewGoogleSearch() *GoogleSearch {
+	return &GoogleSearch{BaseURL: "https://google.com"}
+}
+
+func (g *GoogleSearch) Name() string { return "google" }
+
+func (g *GoogleSearch) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	body, err := getSearchPage(ctx, fmt.Sprintf("%s/search?q=%s&gl&hl=en", g.BaseURL, url.QueryEscape(query)), browserHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing document: %w", err)
+	}
+
+	if doc.Find(`form[action*="consent"]`).Length() > 0 {
+		return nil, fmt.Errorf("got the consent page instead of results")
+	}
+
+	var results []SearchResult
+	doc.Find("div.g").Each(func(i int, s *goquery.Selection) {
+		title := strings.TrimSpace(s.Find("h3").First().Text())
+		link, exists := s.Find("a").First().Attr("href")
+		if !exists {
+			return
+		}
+		link = unwrapGoogleLink(strings.TrimSpace(link))
+		snippet := strings.TrimSpace(s.Find(".VwiC3b").First().Text())
+
+		// relative links point to other google pages, not results
+		if title != "" && strings.HasPrefix(link, "http") {
+			results = append(results, SearchResult{
+				Title:    title,
+				Link:     link,
+				Snippet:  snippet,
+				Position: len(results) + 1,
+			})
+		}
+	})
+
+	return results, nil
+}
+
+// unwrapGoogleLink returns the target of a /url?q= redirect link, Google
+// serves those instead of direct links to clients it doesn't recognize.
+func unwrapGoogleLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Path != "/url" {
+		return link
+	}
+	if u.Host != "" && !strings.Contains(u.Host, "google.") {
+		return link
+	}
+
+	for _, key := range []string{"q", "url"} {
+		if target := u.Query().Get(key); strings.HasPrefix(target, "http") {
+			return target
+		}
+	}
+	return link
+}
+
+// DuckDuckGoSearch scrapes the JavaScript free DuckDuckGo HTML endpoint.
+type DuckDuckGoSearch struct {
+	BaseURL string
+}
+
+func NewDuckDuckGoSearch() *DuckDuckGoSearch {
+	return &DuckDuckGoSearch{BaseURL: "https://html.duckduckgo.com"}
+}
+
+func (d *DuckDuckGoSearch) Name() string { return "duckduckgo" }
+
+func (d *DuckDuckGoSearch) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	body, err := getSearchPage(ctx, fmt.Sprintf("%s/html/?q=%s", d.BaseURL, url.QueryEscape(query)), browserHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing document: %w", err)
+	}
+
+	var results []SearchResult
+	doc.Find("div.result").Each(func(i int, s *goquery.Selection) {
+		// skip ads
+		if s.HasClass("result--ad") {
+			return
+		}
+
+		a := s.Find("a.result__a").First()
+		title := strings.TrimSpace(a.Text())
+		link := unwrapDuckDuckGoLink(strings.TrimSpace(a.AttrOr("href", "")))
+		snippet := strings.TrimSpace(s.Find(".result__snippet").First().Text())
+
+		if title != "" && link != "" {
+			results = append(results, SearchResult{
+				Title:    title,
+				Link:     link,
+				Snippet:  snippet,
+				Position: len(results) + 1,
+			})
+		}
+	})
+
+	return results, nil
+}
+
+// unwrapDuckDuckGoLink returns the target of a //duckduckgo.com/l/?uddg= link.
+func unwrapDuckDuckGoLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Path != "/l/" {
+		return link
+	}
+	if target := u.Query().Get("uddg"); target != "" {
+		return target
+	}
+	return link
+}
+
+// SearXNGSearch uses the JSON API of a SearXNG instance, the instance must
+// have the json format enabled.
+type SearXNGSearch struct {
+	BaseURL string
+}
+
+func NewSearXNGSearch(baseURL string) *SearXNGSearch {
+	return &SearXNGSearch{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (s *SearXNGSearch) Name() string { return "searxng" }
+
+func (s *SearXNGSearch) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	body, err := getSearchPage(ctx, fmt.Sprintf("%s/search?q=%s&format=json", s.BaseURL, url.QueryEscape(query)), map[string]string{
+		"Accept": "application/json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Results []struct {
+			URL     string `json:"url"`
+			Title   string `json:"title"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding results: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(response.Results))
+	for _, r := range response.Results {
+		results = append(results, SearchResult{
+			Title:    r.Title,
+			Link:     r.URL,
+			Snippet:  r.Content,
+			Position: len(results) + 1,
+		})
+	}
+	return results, nil
+}
+
+// BraveSearch uses the Brave Search API.
+type BraveSearch struct {
+	BaseURL string
+	APIKey  string
+}
+
+func NewBraveSearch(apiKey string) *BraveSearch {
+	return &BraveSearch{BaseURL: "https://api.search.brave.com", APIKey: apiKey}
+}
+
+func (b *BraveSearch) Name() string { return "brave" }
+
+func (b *BraveSearch) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	body, err := getSearchPage(ctx, fmt.Sprintf("%s/res/v1/web/search?q=%s", b.BaseURL, url.QueryEscape(query)), map[string]string{
+		"Accept":               "application/json",
+		"X-Subscription-Token": b.APIKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding results: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(response.Web.Results))
+	for _, r := range response.Web.Results {
+		results = append(results, SearchResult{
+			Title:    r.Title,
+			Link:     r.URL,
+			Snippet:  r.Description,
+			Position: len(results) + 1,
+		})
+	}
+	return results, nil
+}
+
+// BingSearch uses the Bing Web Search API.
+type BingSearch struct {
+	BaseURL string
+	APIKey  string
+}
+
+func NewBingSearch(apiKey string) *BingSearch {
+	return &BingSearch{BaseURL: "https://api.bing.microsoft.com", APIKey: apiKey}
+}
+
+func (b *BingSearch) Name() string { return "bing" }
+
+func (b *BingSearch) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	body, err := getSearchPage(ctx, fmt.Sprintf("%s/v7.0/search?q=%s", b.BaseURL, url.QueryEscape(query)), map[string]string{
+		"Accept":                    "application/json",
+		"Ocp-Apim-Subscription-Key": b.APIKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding results: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(response.WebPages.Value))
+	for _, r := range response.WebPages.Value {
+		results = append(results, SearchResult{
+			Title:    r.Name,
+			Link:     r.URL,
+			Snippet:  r.Snippet,
+			Position: len(results) + 1,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,400 @@
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// fixtureServer serves a recorded response for path and records the request.
+func fixtureServer(t *testing.T, path string, fixture string, got *http.Request) *httptest.Server {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "search", fixture))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		*got = *r.Clone(context.Background())
+
+		if strings.HasSuffix(fixture, ".json") {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	allowLoopback(t)
+	return server
+}
+
+func TestSearchProviders(t *testing.T) {
+	rustResults := []SearchResult{
+		{
+			Title:    "Rust Programming Language",
+			Link:     "https://www.rust-lang.org/",
+			Snippet:  "A language empowering everyone to build reliable and efficient software.",
+			Position: 1,
+		},
+		{
+			Title:    "The Rust Programming Language",
+			Link:     "https://doc.rust-lang.org/book/",
+			Snippet:  "The official book on the Rust programming language.",
+			Position: 2,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		fixture    string
+		provider   func(baseURL string) SearchProvider
+		wantHeader map[string]string
+		wantQuery  map[string]string
+		want       []SearchResult
+		wantLen    int
+	}{
+		{
+			name:    "google",
+			path:    "/search",
+			fixture: "google.html",
+			provider: func(baseURL string) SearchProvider {
+				return &GoogleSearch{BaseURL: baseURL}
+			},
+			wantQuery: map[string]string{"q": "rust programming"},
+			wantLen:   4,
+		},
+		{
+			name:    "duckduckgo",
+			path:    "/html/",
+			fixture: "duckduckgo.html",
+			provider: func(baseURL string) SearchProvider {
+				return &DuckDuckGoSearch{BaseURL: baseURL}
+			},
+			wantQuery: map[string]string{"q": "rust programming"},
+			want:      rustResults,
+		},
+		{
+			name:    "searxng",
+			path:    "/search",
+			fixture: "searxng.json",
+			provider: func(baseURL string) SearchProvider {
+				return NewSearXNGSearch(baseURL + "/")
+			},
+			wantQuery: map[string]string{"q": "rust programming", "format": "json"},
+			want:      rustResults,
+		},
+		{
+			name:    "brave",
+			path:    "/res/v1/web/search",
+			fixture: "brave.json",
+			provider: func(baseURL string) SearchProvider {
+				return &BraveSearch{BaseURL: baseURL, APIKey: "brave-key"}
+			},
+			wantHeader: map[string]string{"X-Subscription-Token": "brave-key"},
+			wantQuery:  map[string]string{"q": "rust programming"},
+			want:       rustResults,
+		},
+		{
+			name:    "bing",
+			path:    "/v7.0/search",
+			fixture: "bing.json",
+			provider: func(baseURL string) SearchProvider {
+				return &BingSearch{BaseURL: baseURL, APIKey: "bing-key"}
+			},
+			wantHeader: map[string]string{"Ocp-Apim-Subscription-Key": "bing-key"},
+			wantQuery:  map[string]string{"q": "rust programming"},
+			want:       rustResults,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req http.Request
+			server := fixtureServer(t, tt.path, tt.fixture, &req)
+
+			results, err := tt.provider(server.URL).Search(context.Background(), "rust programming")
+			if err != nil {
+				t.Fatalf("search failed: %v", err)
+			}
+
+			for key, want := range tt.wantQuery {
+				if got := req.URL.Query().Get(key); got != want {
+					t.Errorf("query %s = %q, want %q", key, got, want)
+				}
+			}
+			for key, want := range tt.wantHeader {
+				if got := req.Header.Get(key); got != want {
+					t.Errorf("header %s = %q, want %q", key, got, want)
+				}
+			}
+
+			if tt.want != nil && !reflect.DeepEqual(results, tt.want) {
+				t.Errorf("results mismatch\ngot:  %+v\nwant: %+v", results, tt.want)
+			}
+			if tt.wantLen > 0 && len(results) != tt.wantLen {
+				t.Errorf("got %d results, want %d", len(results), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestGoogleSearchUnwrapsRedirects(t *testing.T) {
+	var req http.Request
+	server := fixtureServer(t, "/search", "google.html", &req)
+
+	results, err := (&GoogleSearch{BaseURL: server.URL}).Search(context.Background(), "rust")
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+
+	last := results[len(results)-1]
+	if last.Link != "https://github.com/rust-lang/rust" {
+		t.Errorf("redirect link not unwrapped: %q", last.Link)
+	}
+
+	for _, r := range results {
+		if !strings.HasPrefix(r.Link, "https://") {
+			t.Errorf("unexpected non-result link %q", r.Link)
+		}
+	}
+}
+
+func TestUnwrapGoogleLink(t *testing.T) {
+	tests := map[string]string{
+		"/url?q=https://example.com/a%3Fb%3Dc&sa=U":           "https://example.com/a?b=c",
+		"https://www.google.com/url?url=https://example.com/": "https://example.com/",
+		"https://example.com/url?q=https://evil.com/":         "https://example.com/url?q=https://evil.com/",
+		"/url?q=javascript:alert(1)":                          "/url?q=javascript:alert(1)",
+		"https://example.com/":                                "https://example.com/",
+	}
+
+	for link, want := range tests {
+		if got := unwrapGoogleLink(link); got != want {
+			t.Errorf("unwrapGoogleLink(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestDedupeByDomain(t *testing.T) {
+	results := []SearchResult{
+		{Link: "https://www.rust-lang.org/"},
+		{Link: "https://rust-lang.org/learn"},
+		{Link: "https://doc.rust-lang.org/book/"},
+		{Link: "not a url"},
+		{Link: "https://WWW.Rust-Lang.org/tools"},
+	}
+
+	got := dedupeByDomain(results)
+	if len(got) != 2 || got[0].Link != results[0].Link || got[1].Link != results[2].Link {
+		t.Errorf("dedupeByDomain() = %+v", got)
+	}
+}
+
+// staticSearch returns the same results for every query.
+type staticSearch []SearchResult
+
+func (s staticSearch) Name() string { return "static" }
+
+func (s staticSearch) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	return s, nil
+}
+
+func TestWebSearchExtractFewResults(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>Page</title></head><body><article><p>Hello</p></article></body></html>"))
+	}))
+	defer page.Close()
+	allowLoopback(t)
+
+	defer SetSearchProviders(searchProviders...)
+
+	for _, results := range []staticSearch{
+		{},
+		{{Title: "Page", Link: page.URL + "/a", Position: 1}},
+		{{Title: "Page", Link: page.URL + "/a", Position: 1}, {Title: "Page", Link: page.URL + "/b", Position: 2}},
+	} {
+		SetSearchProviders(results)
+
+		got, err := callTool(context.Background(), genai.FunctionCall{
+			Name: "web_search",
+			Args: map[string]any{"query": "hello", "extract_websites": true},
+		})
+		if err != nil {
+			t.Fatalf("webSearch with %d results failed: %v", len(results), err)
+		}
+
+		if len(results) == 0 {
+			if !strings.Contains(got, "no_results") {
+				t.Errorf("expected no_results error, got %s", got)
+			}
+			continue
+		}
+
+		// both results are on the same host so only one is extracted
+		if strings.Count(got, `"url"`) != 1 {
+			t.Errorf("expected one extracted page, got %s", got)
+		}
+	}
+}
+
+func TestScrapeWebsitesReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello"))
+		case "/empty":
+			w.Header().Set("Content-Type", "text/plain")
+		case "/forbidden":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	defer server.Close()
+	allowLoopback(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	links := []string{
+		server.URL + "/ok",
+		server.URL + "/empty",
+		server.URL + "/forbidden",
+		server.URL + "/slow",
+		"http://does-not-exist.invalid/",
+		"file:///etc/passwd",
+	}
+	var pages []WebPageData
+	if err := json.Unmarshal([]byte(scrapeWebsites(ctx, links)), &pages); err != nil {
+		t.Fatalf("invalid results: %v", err)
+	}
+	if len(pages) != len(links) {
+		t.Fatalf("got %d pages, want one per link", len(pages))
+	}
+
+	want := []struct {
+		status     string
+		httpStatus int
+		errorKind  string
+	}{
+		{pageOK, http.StatusOK, ""},
+		{pageEmpty, http.StatusOK, ""},
+		{pageError, http.StatusForbidden, errKindHTTPStatus},
+		{pageError, 0, errKindTimeout},
+		{pageError, 0, errKindDNS},
+		{pageSkipped, 0, errKindBlocked},
+	}
+	for i, page := range pages {
+		if page.URL != links[i] {
+			t.Errorf("page %d is %s, want %s", i, page.URL, links[i])
+		}
+		if page.Status != want[i].status || page.HTTPStatus != want[i].httpStatus || page.ErrorKind != want[i].errorKind {
+			t.Errorf("%s: got status %q, http %d, kind %q, want %+v", page.URL, page.Status, page.HTTPStatus, page.ErrorKind, want[i])
+		}
+		if (page.ErrorKind != "") != (page.Error != "") {
+			t.Errorf("%s: error kind %q with error %q", page.URL, page.ErrorKind, page.Error)
+		}
+	}
+	if pages[0].Content != "hello" {
+		t.Errorf("successful page lost its content: %q", pages[0].Content)
+	}
+	if pages[3].ElapsedMS < 400 {
+		t.Errorf("timed out page took %dms, want about 500ms", pages[3].ElapsedMS)
+	}
+}
+
+func TestGoogleSearchConsentPage(t *testing.T) {
+	var req http.Request
+	server := fixtureServer(t, "/search", "google_consent.html", &req)
+
+	_, err := (&GoogleSearch{BaseURL: server.URL}).Search(context.Background(), "rust")
+	if err == nil || !strings.Contains(err.Error(), "consent") {
+		t.Errorf("expected consent page error, got %v", err)
+	}
+}
+
+func TestSearchFallback(t *testing.T) {
+	var req http.Request
+	consent := fixtureServer(t, "/search", "google_consent.html", &req)
+	ddg := fixtureServer(t, "/html/", "duckduckgo.html", &req)
+
+	defer SetSearchProviders(searchProviders...)
+	SetSearchProviders(
+		&BraveSearch{BaseURL: "http://127.0.0.1:1", APIKey: "unreachable"},
+		&GoogleSearch{BaseURL: consent.URL},
+		&DuckDuckGoSearch{BaseURL: ddg.URL},
+	)
+
+	results, provider, err := search(context.Background(), "rust")
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if provider != "duckduckgo" {
+		t.Errorf("provider = %q, want duckduckgo", provider)
+	}
+	if len(results) != 2 {
+		t.Errorf("got %d results, want 2", len(results))
+	}
+
+	SetSearchProviders(&GoogleSearch{BaseURL: consent.URL})
+	if _, _, err := search(context.Background(), "rust"); err == nil || errors.Is(err, errNoResults) {
+		t.Errorf("every provider failed: err = %v", err)
+	}
+
+	// one provider answered, the query found nothing
+	SetSearchProviders(&GoogleSearch{BaseURL: consent.URL}, staticSearch{})
+	_, _, err = search(context.Background(), "rust")
+	if !errors.Is(err, errNoResults) || !strings.Contains(err.Error(), "consent") {
+		t.Errorf("err = %v, want errNoResults with the google error", err)
+	}
+	got, err := callTool(context.Background(), genai.FunctionCall{
+		Name: "web_search",
+		Args: map[string]any{"query": "rust", "extract_websites": false},
+	})
+	if err != nil || !strings.Contains(got, "no_results") {
+		t.Errorf("web_search = %s, %v, want the no_results response", got, err)
+	}
+}
+
+func TestNewSearchProvider(t *testing.T) {
+	for _, name := range []string{"google", "duckduckgo", "DDG"} {
+		if _, err := NewSearchProvider(name, ""); err != nil {
+			t.Errorf("NewSearchProvider(%q) failed: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"searxng", "brave", "bing"} {
+		if _, err := NewSearchProvider(name, ""); err == nil {
+			t.Errorf("NewSearchProvider(%q) without option should fail", name)
+		}
+		if _, err := NewSearchProvider(name, "option"); err != nil {
+			t.Errorf("NewSearchProvider(%q) failed: %v", name, err)
+		}
+	}
+
+	if _, err := NewSearchProvider("altavista", ""); err == nil {
+		t.Error("expected unknown provider error")
+	}
+}
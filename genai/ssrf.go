@@ -0,0 +1,245 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// errBlockedURL is returned for URLs the bot must not fetch, mostly because
+// the model was tricked into pointing it at internal services.
+var errBlockedURL = errors.New("blocked URL")
+
+// blockedPrefixes are special purpose ranges not covered by the netip
+// helpers used in isPublicAddr.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, embeds IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001::/32"),      // Teredo, embeds IPv4 addresses
+	netip.MustParsePrefix("2002::/16"),      // 6to4, embeds IPv4 addresses
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("100::/64"),       // discard-only
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+}
+
+// URLPolicy decides which URLs and addresses webClient may connect to.
+// Only http and https URLs are fetched, and only from public addresses
+// unless the host is allowed explicitly.
+type URLPolicy struct {
+	allow []hostRule
+	deny  []hostRule
+}
+
+// hostRule matches a host name and its subdomains, or an address range, on
+// port or on every port when port is empty.
+type hostRule struct {
+	name   string
+	prefix netip.Prefix
+	port   string
+}
+
+// urlPolicy guards every request made through webClient
+var urlPolicy = &URLPolicy{}
+
+// NewURLPolicy builds a policy from host names, IP addresses and CIDR
+// ranges. Host names and addresses may name a port, "localhost:8888", to
+// match only that port. Allowed entries may be private, like a self-hosted
+// SearXNG instance; denied entries are never fetched, even when public.
+func NewURLPolicy(allow, deny []string) (*URLPolicy, error) {
+	p := &URLPolicy{}
+	var err error
+	if p.allow, err = parseHostRules(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parseHostRules(deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetURLPolicy sets the policy for search and scraping requests.
+func SetURLPolicy(policy *URLPolicy) {
+	urlPolicy = policy
+}
+
+func parseHostRules(entries []string) ([]hostRule, error) {
+	var rules []hostRule
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q: %v", entry, err)
+			}
+			rules = append(rules, hostRule{prefix: prefix.Masked()})
+			continue
+		}
+
+		var port string
+		if host, p, err := net.SplitHostPort(entry); err == nil {
+			if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("invalid port in %q", entry)
+			}
+			entry, port = host, p
+		}
+
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			rules = append(rules, hostRule{prefix: netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), port: port})
+			continue
+		}
+
+		rules = append(rules, hostRule{name: strings.TrimPrefix(strings.TrimSuffix(entry, "."), "*."), port: port})
+	}
+	return rules, nil
+}
+
+func matchHost(rules []hostRule, host, port string, addr netip.Addr) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, rule := range rules {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if rule.name != "" && host != "" && (host == rule.name || strings.HasSuffix(host, "."+rule.name)) {
+			return true
+		}
+		if rule.prefix.IsValid() && addr.IsValid() && rule.prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL rejects URLs that can be told apart as unsafe without a DNS
+// lookup. Addresses host names resolve to are checked when dialing.
+func (p *URLPolicy) CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q is not allowed, only http and https", errBlockedURL, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: URLs with credentials are not allowed", errBlockedURL)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", errBlockedURL)
+	}
+
+	port := urlPort(u)
+	addr, _ := netip.ParseAddr(host)
+	if matchHost(p.deny, host, port, addr.Unmap()) {
+		return fmt.Errorf("%w: %s is on the deny list", errBlockedURL, host)
+	}
+	if matchHost(p.allow, host, port, addr.Unmap()) {
+		return nil
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if name == "localhost" || strings.HasSuffix(name, ".localhost") {
+		return fmt.Errorf("%w: %s is a loopback host", errBlockedURL, host)
+	}
+	if addr.IsValid() {
+		return p.checkAddr(host, port, addr)
+	}
+	return nil
+}
+
+// urlPort is the port u connects to, the scheme's default when it has none.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// checkAddr rejects addr, which host resolved to, unless it's public or
+// allowed explicitly for port.
+func (p *URLPolicy) checkAddr(host, port string, addr netip.Addr) error {
+	addr = addr.Unmap()
+	if matchHost(p.deny, host, port, addr) {
+		return fmt.Errorf("%w: %s (%s) is on the deny list", errBlockedURL, host, addr)
+	}
+	if matchHost(p.allow, host, port, addr) {
+		return nil
+	}
+	if !isPublicAddr(addr) {
+		return fmt.Errorf("%w: %s resolves to non-public address %s", errBlockedURL, host, addr)
+	}
+	return nil
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.WithZone("").Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// guardedDialContext resolves the host itself and connects only to
+// addresses urlPolicy accepts, so a host name can't resolve to a safe
+// address during a check and to an internal one when connecting.
+func guardedDialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		p := urlPolicy
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for _, addr := range addrs {
+			if err := p.checkAddr(host, port, addr); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("no addresses found for %s", host)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// checkRedirect applies the policy to every redirect webClient follows.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return urlPolicy.CheckURL(req.URL)
+}
@@ -0,0 +1,180 @@
+package genai
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	maxMessageLength = 4096
+	// Telegram starts returning 429s when a chat is edited too often
+	streamEditInterval = time.Second
+)
+
+type editLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	lastEdit map[int]time.Time
+}
+
+var streamEdits = &editLimiter{
+	interval: streamEditInterval,
+	lastEdit: make(map[int]time.Time),
+}
+
+func (l *editLimiter) allow(chatID int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastEdit[chatID]) < l.interval {
+		return false
+	}
+
+	// edits older than interval don't limit anything, forget them so the
+	// map only holds the chats streaming right now
+	for id, last := range l.lastEdit {
+		if now.Sub(last) >= l.interval {
+			delete(l.lastEdit, id)
+		}
+	}
+	l.lastEdit[chatID] = now
+	return true
+}
+
+// sendStream sends parts with SendMessageStream and appends the text to the
+// turn's message as it arrives. Once the message would go over the Telegram
+// limit the text continues in a new message. The merged response is returned
+// so function calls can be handled the same way as in the non-streaming path.
+func (t *turn) sendStream(parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	iter := t.cs.SendMessageStream(t.ctx, parts...)
+
+	var shown strings.Builder
+	rendered := ""
+	streamedText := false
+
+	render := func(force bool) {
+		text := shown.String()
+		// editing a message to the same text is an error in Telegram
+		if strings.TrimSpace(text) == "" || text == rendered {
+			return
+		}
+		if !force && !streamEdits.allow(t.chatID) {
+			return
+		}
+		t.bot.HandleUpdateMessage(t.chatID, t.messageID, text)
+		rendered = text
+	}
+
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, text := range responseTexts(resp) {
+			streamedText = true
+			shown.WriteString(text)
+
+			for shown.Len() > maxMessageLength {
+				chunk, rest := splitChunk(shown.String(), maxMessageLength)
+
+				shown.Reset()
+				shown.WriteString(chunk)
+				render(true)
+
+				t.rollover()
+				shown.Reset()
+				shown.WriteString(rest)
+				rendered = ""
+			}
+
+			render(false)
+		}
+	}
+
+	render(true)
+
+	merged := iter.MergedResponse()
+
+	// keep the streamed text and show the tool progress in a new message
+	if streamedText && hasFunctionCall(merged) {
+		t.rollover()
+	}
+
+	return merged, nil
+}
+
+// rollover continues the turn's output in a new loading message.
+func (t *turn) rollover() {
+	messageID, err := t.bot.SendLoadingMessage(t.chatID, "⏳")
+	if err != nil {
+		log.Printf("Error sending rollover message: %v", err)
+		return
+	}
+	t.messageID = messageID
+}
+
+func responseTexts(resp *genai.GenerateContentResponse) []string {
+	var texts []string
+	if resp == nil {
+		return texts
+	}
+
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				texts = append(texts, string(text))
+			}
+		}
+	}
+	return texts
+}
+
+func hasFunctionCall(resp *genai.GenerateContentResponse) bool {
+	if resp == nil {
+		return false
+	}
+
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if _, ok := part.(genai.FunctionCall); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitChunk splits text at the last newline or space before maxLength and
+// never in the middle of a UTF-8 sequence.
+func splitChunk(text string, maxLength int) (string, string) {
+	if len(text) <= maxLength {
+		return text, ""
+	}
+
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+
+	if i := strings.LastIndexAny(text[:cut], "\n "); i > 0 {
+		return text[:i], text[i+1:]
+	}
+	return text[:cut], text[cut:]
+}
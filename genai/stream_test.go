@@ -0,0 +1,292 @@
+package genai
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// streamChat streams chunks in answer to every message.
+type streamChat struct {
+	chunks []*genai.GenerateContentResponse
+}
+
+func (c *streamChat) SendMessage(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	panic("streamChat only streams")
+}
+
+func (c *streamChat) SendMessageStream(ctx context.Context, parts ...genai.Part) ResponseIterator {
+	return &fakeStream{chunks: c.chunks}
+}
+
+// fakeStream merges the chunks like the Gemini iterator, joining adjacent
+// text.
+type fakeStream struct {
+	chunks []*genai.GenerateContentResponse
+	merged []genai.Part
+}
+
+func (s *fakeStream) Next() (*genai.GenerateContentResponse, error) {
+	if len(s.chunks) == 0 {
+		return nil, iterator.Done
+	}
+	resp := s.chunks[0]
+	s.chunks = s.chunks[1:]
+
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if text, ok := part.(genai.Text); ok && len(s.merged) > 0 {
+			if last, ok := s.merged[len(s.merged)-1].(genai.Text); ok {
+				s.merged[len(s.merged)-1] = last + text
+				continue
+			}
+		}
+		s.merged = append(s.merged, part)
+	}
+	return resp, nil
+}
+
+func (s *fakeStream) MergedResponse() *genai.GenerateContentResponse {
+	return modelResponse(s.merged...)
+}
+
+// messageBot keeps the text of every message by ID, the loading message of
+// the turn is 1.
+type messageBot struct {
+	fakeBot
+	mu    sync.Mutex
+	ids   []int
+	texts map[int]string
+}
+
+func newMessageBot() *messageBot {
+	return &messageBot{ids: []int{1}, texts: map[int]string{1: "⏳"}}
+}
+
+func (b *messageBot) newMessage(text string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.ids[len(b.ids)-1] + 1
+	b.ids = append(b.ids, id)
+	b.texts[id] = text
+	return id
+}
+
+func (b *messageBot) HandleSendMessage(chatID int, text string) error {
+	b.newMessage(text)
+	return nil
+}
+
+func (b *messageBot) SendLoadingMessage(chatID int, text string) (int, error) {
+	return b.newMessage(text), nil
+}
+
+func (b *messageBot) HandleUpdateMessage(chatID int, messageID int, text string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.texts[messageID] = text
+	return nil
+}
+
+// messages returns the text of every message in the order they were sent.
+func (b *messageBot) messages() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	texts := make([]string, len(b.ids))
+	for i, id := range b.ids {
+		texts[i] = b.texts[id]
+	}
+	return texts
+}
+
+func streamTurn(chunks ...*genai.GenerateContentResponse) (*turn, *messageBot) {
+	bot := newMessageBot()
+	return &turn{ctx: context.Background(), cs: &streamChat{chunks: chunks}, bot: bot, chatID: 9301, messageID: 1, streaming: true}, bot
+}
+
+func TestSendStreamRollsOverLongText(t *testing.T) {
+	var chunks []*genai.GenerateContentResponse
+	var full strings.Builder
+	for i := 0; i < 90; i++ {
+		text := strings.Repeat("streamed words ", 7)
+		full.WriteString(text)
+		chunks = append(chunks, modelResponse(genai.Text(text)))
+	}
+	tr, bot := streamTurn(chunks...)
+
+	resp, err := tr.sendStream(genai.Text("write a lot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := bot.messages()
+	if len(messages) != 3 {
+		t.Fatalf("%d messages for %d bytes, want 3", len(messages), full.Len())
+	}
+	for i, message := range messages {
+		if len(message) > maxMessageLength {
+			t.Errorf("message %d is %d bytes", i, len(message))
+		}
+	}
+	if got := strings.Fields(strings.Join(messages, " ")); strings.Join(got, " ") != strings.TrimSpace(full.String()) {
+		t.Error("streamed text was lost across the messages")
+	}
+	if tr.messageID != 3 {
+		t.Errorf("turn continues in message %d, want 3", tr.messageID)
+	}
+	if texts := responseTexts(resp); len(texts) != 1 || texts[0] != full.String() {
+		t.Error("merged response doesn't hold the whole text")
+	}
+}
+
+func TestSendStreamFunctionCalls(t *testing.T) {
+	call := genai.FunctionCall{Name: "web_search", Args: map[string]any{"query": "go"}}
+
+	t.Run("after text", func(t *testing.T) {
+		tr, bot := streamTurn(
+			modelResponse(genai.Text("Let me look that up.")),
+			modelResponse(call),
+		)
+
+		resp, err := tr.sendStream(genai.Text("search go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasFunctionCall(resp) {
+			t.Fatal("function call missing from the merged response")
+		}
+
+		// the tool progress goes to a new message below the text
+		messages := bot.messages()
+		if len(messages) != 2 || messages[0] != "Let me look that up." || tr.messageID != 2 {
+			t.Errorf("messages %q, turn in message %d", messages, tr.messageID)
+		}
+	})
+
+	t.Run("mid-stream", func(t *testing.T) {
+		tr, bot := streamTurn(
+			modelResponse(genai.Text("Checking")),
+			modelResponse(call),
+			modelResponse(genai.Text(" twice.")),
+		)
+
+		resp, err := tr.sendStream(genai.Text("search go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasFunctionCall(resp) || strings.Join(responseTexts(resp), "") != "Checking twice." {
+			t.Errorf("merged response = %v", resp.Candidates[0].Content.Parts)
+		}
+		if messages := bot.messages(); len(messages) != 2 || messages[0] != "Checking twice." {
+			t.Errorf("messages %q", messages)
+		}
+	})
+
+	t.Run("no text", func(t *testing.T) {
+		tr, bot := streamTurn(modelResponse(call))
+
+		if _, err := tr.sendStream(genai.Text("search go")); err != nil {
+			t.Fatal(err)
+		}
+		// nothing to keep, the progress replaces the loading message
+		if messages := bot.messages(); len(messages) != 1 || tr.messageID != 1 {
+			t.Errorf("messages %q, turn in message %d", messages, tr.messageID)
+		}
+	})
+}
+
+func TestRenderResponseSplitsLongText(t *testing.T) {
+	text := strings.Repeat("word ", 841) + "end"
+	bot := newMessageBot()
+	tr := &turn{ctx: context.Background(), bot: bot, chatID: 9302, messageID: 1}
+
+	renderResponse(tr, modelResponse(genai.Text(text)))
+
+	messages := bot.messages()
+	if len(messages) != 2 {
+		t.Fatalf("%d messages, want 2", len(messages))
+	}
+	if got := strings.Join(messages, " "); got != text {
+		t.Errorf("split lost text: %d bytes in, %d out", len(text), len(got))
+	}
+}
+
+func TestEditLimiterForgetsOldEdits(t *testing.T) {
+	l := &editLimiter{interval: 20 * time.Millisecond, lastEdit: make(map[int]time.Time)}
+
+	if !l.allow(1) || l.allow(1) || !l.allow(2) {
+		t.Fatal("edits within the interval weren't limited")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !l.allow(3) {
+		t.Fatal("edit of another chat was limited")
+	}
+	if len(l.lastEdit) != 1 {
+		t.Errorf("limiter remembers %d chats, want 1", len(l.lastEdit))
+	}
+}
+
+func TestSplitChunk(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxLength int
+		wantChunk string
+		wantRest  string
+	}{
+		{
+			name:      "under limit",
+			text:      "hello world",
+			maxLength: 20,
+			wantChunk: "hello world",
+			wantRest:  "",
+		},
+		{
+			name:      "split at space",
+			text:      "hello world again",
+			maxLength: 13,
+			wantChunk: "hello world",
+			wantRest:  "again",
+		},
+		{
+			name:      "split at newline",
+			text:      "hello\nworld again",
+			maxLength: 8,
+			wantChunk: "hello",
+			wantRest:  "world again",
+		},
+		{
+			name:      "no whitespace",
+			text:      "abcdefghij",
+			maxLength: 4,
+			wantChunk: "abcd",
+			wantRest:  "efghij",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, rest := splitChunk(tt.text, tt.maxLength)
+			if chunk != tt.wantChunk || rest != tt.wantRest {
+				t.Errorf("splitChunk() = %q, %q, want %q, %q", chunk, rest, tt.wantChunk, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestSplitChunkKeepsRunesIntact(t *testing.T) {
+	text := strings.Repeat("⏳", 10)
+
+	chunk, rest := splitChunk(text, 7)
+	if !utf8.ValidString(chunk) || !utf8.ValidString(rest) {
+		t.Fatalf("split produced invalid UTF-8: %q, %q", chunk, rest)
+	}
+	if chunk+rest != text {
+		t.Errorf("split lost content: %q + %q", chunk, rest)
+	}
+}
@@ -0,0 +1,103 @@
+package genai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Summarizer folds history entries that are about to be trimmed into the
+// running summary of a chat.
+type Summarizer interface {
+	Summarize(ctx context.Context, previous string, dropped []Conversation) (string, error)
+}
+
+// ModelSummarizer asks Gemini to write the summary. Model should be a plain
+// model without tools or the chat system prompt.
+type ModelSummarizer struct {
+	Model Model
+}
+
+func (s ModelSummarizer) Summarize(ctx context.Context, previous string, dropped []Conversation) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString(SummaryPrompt)
+
+	if previous != "" {
+		prompt.WriteString("\n\nCurrent summary:\n")
+		prompt.WriteString(previous)
+	}
+
+	prompt.WriteString("\n\nNew conversation turns:\n")
+	prompt.WriteString(transcript(dropped))
+
+	resp, err := s.Model.GenerateContent(ctx, genai.Text(prompt.String()))
+	if err != nil {
+		return "", err
+	}
+
+	summary := strings.TrimSpace(strings.Join(responseTexts(resp), ""))
+	if summary == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return summary, nil
+}
+
+// maxTranscriptPartLength keeps huge tool results (scraped pages) from
+// dominating the summary prompt.
+const maxTranscriptPartLength = 2000
+
+func transcript(entries []Conversation) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		for _, part := range entry.Parts {
+			var line string
+			switch v := part.(type) {
+			case genai.Text:
+				line = string(v)
+			case genai.FunctionCall:
+				args, _ := json.Marshal(v.Args)
+				line = fmt.Sprintf("[called %s with %s]", v.Name, args)
+			case genai.FunctionResponse:
+				response, _ := json.Marshal(v.Response)
+				line = fmt.Sprintf("[%s returned %s]", v.Name, response)
+			case genai.Blob:
+				line = fmt.Sprintf("[attached %s file]", v.MIMEType)
+			default:
+				continue
+			}
+
+			if len(line) > maxTranscriptPartLength {
+				cut := maxTranscriptPartLength
+				for cut > 0 && !utf8.RuneStart(line[cut]) {
+					cut--
+				}
+				line = line[:cut] + "..."
+			}
+
+			sb.WriteString(entry.Role)
+			sb.WriteString(": ")
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// summaryConversation is the synthetic user/model pair that puts the summary
+// in front of the chat history.
+func summaryConversation(summary string) []Conversation {
+	return []Conversation{
+		{
+			Role:  "user",
+			Parts: []genai.Part{genai.Text("Summary of our earlier conversation:\n" + summary)},
+		},
+		{
+			Role:  "model",
+			Parts: []genai.Part{genai.Text("Got it, I'll keep that in mind.")},
+		},
+	}
+}
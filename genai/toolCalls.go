@@ -0,0 +1,128 @@
+package genai
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// how long a single tool call may run unless the tool has its own timeout
+var defaultToolTimeout = time.Minute
+
+// timeoutTool is implemented by tools that need a different timeout than
+// defaultToolTimeout.
+type timeoutTool interface {
+	Timeout() time.Duration
+}
+
+type timedTool struct {
+	Tool
+	timeout func() time.Duration
+}
+
+func (t timedTool) Timeout() time.Duration {
+	return t.timeout()
+}
+
+// WithTimeout gives tool its own timeout. It is asked on every call, so it
+// can depend on settings made at startup after the tool was registered.
+func WithTimeout(tool Tool, timeout func() time.Duration) Tool {
+	return timedTool{Tool: tool, timeout: timeout}
+}
+
+func toolTimeout(tool Tool) time.Duration {
+	if t, ok := tool.(timeoutTool); ok {
+		return t.Timeout()
+	}
+	return defaultToolTimeout
+}
+
+// toolResult is the outcome of one function call, err is reported to the
+// model instead of result.
+type toolResult struct {
+	call   genai.FunctionCall
+	result string
+	err    error
+}
+
+// response is the FunctionResponse part answering the call.
+func (r toolResult) response() genai.FunctionResponse {
+	if r.err != nil {
+		return genai.FunctionResponse{
+			Name:     r.call.Name,
+			Response: map[string]any{"error": r.err.Error()},
+		}
+	}
+	return genai.FunctionResponse{
+		Name:     r.call.Name,
+		Response: map[string]any{"function response: ": r.result},
+	}
+}
+
+// executeToolCalls runs every call concurrently, each with its own timeout,
+// and returns the results in the order of calls.
+func executeToolCalls(ctx context.Context, calls []genai.FunctionCall) []toolResult {
+	results := make([]toolResult, len(calls))
+
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call genai.FunctionCall) {
+			defer wg.Done()
+			result, err := executeToolCall(ctx, call)
+			results[i] = toolResult{call: call, result: result, err: err}
+		}(i, call)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func executeToolCall(ctx context.Context, call genai.FunctionCall) (result string, err error) {
+	tool, err := getTool(call.Name)
+	if err != nil {
+		logWithTime("Error retrieving tool: %v\n", err)
+		return "", fmt.Errorf("Tool '%s' not found.", call.Name)
+	}
+
+	timeout := toolTimeout(tool)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	// buffered so a tool ignoring ctx can still finish after the timeout
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logWithTime("Recovered from panic in tool '%s': %v", call.Name, r)
+				done <- outcome{err: fmt.Errorf("tool %s failed unexpectedly", call.Name)}
+			}
+		}()
+
+		result, err := tool.Execute(ctx, call.Args)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		if o.err != nil {
+			logWithTime("Error executing tool '%s': %v\n", call.Name, o.err)
+			return "", o.err
+		}
+		logWithTime("%s Function executed successfully", call.Name)
+		return o.result, nil
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			return "", fmt.Errorf("tool %s was canceled", call.Name)
+		}
+		logWithTime("Tool '%s' timed out after %v", call.Name, timeout)
+		return "", fmt.Errorf("tool %s timed out after %v", call.Name, timeout)
+	}
+}
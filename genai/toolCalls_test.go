@@ -0,0 +1,117 @@
+package genai
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+type sleepArgs struct {
+	Millis int    `json:"millis"`
+	Reply  string `json:"reply"`
+}
+
+// registerTestTool registers tool for the rest of the test.
+func registerTestTool(t *testing.T, tool Tool) {
+	t.Helper()
+
+	Register(tool)
+	t.Cleanup(func() {
+		delete(registry, tool.Name())
+		registryOrder = registryOrder[:len(registryOrder)-1]
+	})
+}
+
+func TestExecuteToolCalls(t *testing.T) {
+	registerTestTool(t, WithTimeout(NewTool("test_sleep", "", func(ctx context.Context, args sleepArgs) (string, error) {
+		select {
+		case <-time.After(time.Duration(args.Millis) * time.Millisecond):
+			return args.Reply, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}), func() time.Duration { return 500 * time.Millisecond }))
+	registerTestTool(t, NewTool("test_panic", "", func(ctx context.Context, args struct{}) (string, error) {
+		panic("boom")
+	}))
+
+	calls := []genai.FunctionCall{
+		{Name: "test_sleep", Args: map[string]any{"millis": float64(200), "reply": "first"}},
+		{Name: "test_sleep", Args: map[string]any{"millis": float64(10), "reply": "second"}},
+		{Name: "test_sleep", Args: map[string]any{"millis": float64(5000), "reply": "too slow"}},
+		{Name: "missing_tool", Args: map[string]any{}},
+		{Name: "test_sleep", Args: map[string]any{"reply": "no millis"}},
+		{Name: "test_panic", Args: map[string]any{}},
+		{Name: "test_sleep", Args: map[string]any{"millis": float64(200), "reply": "third"}},
+	}
+
+	start := time.Now()
+	results := executeToolCalls(context.Background(), calls)
+	elapsed := time.Since(start)
+
+	// the 200ms calls and the timed out one ran at the same time
+	if elapsed > 1500*time.Millisecond {
+		t.Errorf("calls took %v, expected them to run concurrently", elapsed)
+	}
+
+	want := []struct {
+		result string
+		errMsg string
+	}{
+		{result: "first"},
+		{result: "second"},
+		{errMsg: "timed out"},
+		{errMsg: "not found"},
+		{errMsg: "invalid or missing millis argument"},
+		{errMsg: "failed unexpectedly"},
+		{result: "third"},
+	}
+
+	if len(results) != len(calls) {
+		t.Fatalf("got %d results for %d calls", len(results), len(calls))
+	}
+	for i, r := range results {
+		if r.call.Name != calls[i].Name {
+			t.Errorf("result %d is for %s, want %s", i, r.call.Name, calls[i].Name)
+		}
+
+		response := r.response()
+		if response.Name != calls[i].Name {
+			t.Errorf("response %d is named %s, want %s", i, response.Name, calls[i].Name)
+		}
+
+		if want[i].errMsg != "" {
+			if r.err == nil || !strings.Contains(r.err.Error(), want[i].errMsg) {
+				t.Errorf("result %d: expected error containing %q, got %v", i, want[i].errMsg, r.err)
+			}
+			if response.Response["error"] == nil {
+				t.Errorf("response %d doesn't carry the error: %v", i, response.Response)
+			}
+			continue
+		}
+		if r.err != nil || r.result != want[i].result {
+			t.Errorf("result %d = %q, %v, want %q", i, r.result, r.err, want[i].result)
+		}
+	}
+}
+
+func TestToolTimeouts(t *testing.T) {
+	readFile, _ := getTool("read_file")
+	if toolTimeout(readFile) != defaultToolTimeout {
+		t.Errorf("read_file timeout = %v, want the default", toolTimeout(readFile))
+	}
+
+	// web_search has to fit trying every provider and scraping the results
+	defer SetSearchProviders(searchProviders...)
+	webSearch, _ := getTool("web_search")
+	provider := searchProviders[0]
+	SetSearchProviders(provider)
+	one := toolTimeout(webSearch)
+	SetSearchProviders(provider, provider, provider)
+	if three := toolTimeout(webSearch); three <= one || one <= scrapeTimeout {
+		t.Errorf("web_search timeouts: %v with one provider, %v with three", one, three)
+	}
+}
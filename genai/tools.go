@@ -2,95 +2,288 @@ package genai
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 
 	"github.com/google/generative-ai-go/genai"
 )
 
-var availableTools = map[string]func(ctx context.Context, args genai.FunctionCall) (string, error){
-	"create_file":      createFile,
-	"read_file":        readFile,
-	"web_search":       webSearch,
-	"extract_websites": extractWebPagesContent,
+// Tool is a function the model can call.
+type Tool interface {
+	Name() string
+	Declaration() *genai.FunctionDeclaration
+	Execute(ctx context.Context, args map[string]any) (string, error)
 }
 
-func getTool(name string) (func(ctx context.Context, args genai.FunctionCall) (string, error), error) {
-	tool, ok := availableTools[name]
-	if !ok {
+var (
+	registry = make(map[string]Tool)
+	// declarations are sent in registration order
+	registryOrder []string
+	// tools turned off in the config, hidden from the model and never run
+	disabledTools = map[string]bool{}
+)
+
+// Register makes tool available to the model. It panics if a tool with the
+// same name is already registered.
+func Register(tool Tool) {
+	name := tool.Name()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("genai: tool %q registered twice", name))
+	}
+	registry[name] = tool
+	registryOrder = append(registryOrder, name)
+}
+
+// SetDisabledTools turns off the named tools, the model isn't told about
+// them and calls to them fail as if they didn't exist.
+func SetDisabledTools(names ...string) {
+	disabledTools = make(map[string]bool, len(names))
+	for _, name := range names {
+		disabledTools[name] = true
+	}
+}
+
+func getTool(name string) (Tool, error) {
+	tool, ok := registry[name]
+	if !ok || disabledTools[name] {
 		return nil, fmt.Errorf("tool not found")
 	}
 	return tool, nil
 }
 
-var tools = &genai.Tool{
-	FunctionDeclarations: []*genai.FunctionDeclaration{
-		// {
-		// 	Name:        "read_file",
-		// 	Description: "Read content from a file",
-		// 	Parameters: &genai.Schema{
-		// 		Type: genai.TypeObject,
-		// 		Properties: map[string]*genai.Schema{
-		// 			"file_name": {
-		// 				Type:        genai.TypeString,
-		// 				Description: "Name of the file to read",
-		// 			},
-		// 		},
-		// 		Required: []string{"file_name"},
-		// 	},
-		// },
-		{
-			Name:        "create_file",
-			Description: "Creates a file for given content and filename",
-			Parameters: &genai.Schema{
-				Type: genai.TypeObject,
-				Properties: map[string]*genai.Schema{
-					"file_name": {
-						Type:        genai.TypeString,
-						Description: "file name without the extension for example : rust_book , i'll add extension later (.txt)",
-					},
-					"file_content": {
-						Type:        genai.TypeString,
-						Description: "File conten which will be written to file it should be string",
-					},
-				},
-				Required: []string{"file_name", "file_content"},
-			},
-		},
-		{
-			Name:        "web_search",
-			Description: "Perform a web search and optionally extract data from top search results.",
-			Parameters: &genai.Schema{
-				Type: genai.TypeObject,
-				Properties: map[string]*genai.Schema{
-					"query": {
-						Type:        genai.TypeString,
-						Description: "The search query to execute on the web (returns top search results).",
-					},
-					"extract_websites": {
-						Type:        genai.TypeBoolean,
-						Description: "If true, data will be extracted from each top search result.",
-					},
-				},
-				Required: []string{"query", "extract_websites"},
-			},
-		},
-		{
-			Name:        "extract_websites",
-			Description: "Retrieve and extract relevant data from provided website links to address user queries effectively.",
-			Parameters: &genai.Schema{
-				Type: genai.TypeObject,
-				Properties: map[string]*genai.Schema{
-					"links": {
-						Type:        genai.TypeArray,
-						Description: "An array of links from which data needs to be extracted.",
-						Items: &genai.Schema{
-							Type:        genai.TypeString,
-							Description: "A link to scrape",
-						},
-					},
-				},
-				Required: []string{"links"},
-			},
+// toolDeclarations declares every registered tool to the model.
+func toolDeclarations() *genai.Tool {
+	declarations := make([]*genai.FunctionDeclaration, 0, len(registryOrder))
+	for _, name := range registryOrder {
+		if disabledTools[name] {
+			continue
+		}
+		declarations = append(declarations, registry[name].Declaration())
+	}
+	return &genai.Tool{FunctionDeclarations: declarations}
+}
+
+// schemaAdjuster is implemented by argument structs that need more than
+// their tags can express, like an enum kept in a variable.
+type schemaAdjuster interface {
+	adjustSchema(schema *genai.Schema)
+}
+
+// funcTool is a Tool whose arguments are decoded into an Args struct.
+type funcTool[Args any] struct {
+	declaration *genai.FunctionDeclaration
+	fields      []argField
+	fn          func(ctx context.Context, args Args) (string, error)
+}
+
+type argField struct {
+	name     string
+	index    int
+	required bool
+	schema   *genai.Schema
+}
+
+// NewTool builds a tool calling fn. Its parameters are generated from the
+// exported fields of Args: the json tag names a parameter and omitempty
+// makes it optional, the description tag describes it and the enum tag
+// lists the values the model may pick for a string, separated by commas.
+// Required strings must not be blank and enum values must be one of the
+// listed ones, an adjusted enum included. It panics if Args isn't a struct of
+// supported types.
+func NewTool[Args any](name, description string, fn func(ctx context.Context, args Args) (string, error)) Tool {
+	var zero Args
+	argsType := reflect.TypeOf(zero)
+	if argsType == nil || argsType.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("genai: arguments of tool %q must be a struct", name))
+	}
+
+	fields, err := argFields(argsType)
+	if err != nil {
+		panic(fmt.Sprintf("genai: arguments of tool %q: %v", name, err))
+	}
+
+	parameters := objectSchema(fields)
+	if adjuster, ok := any(zero).(schemaAdjuster); ok {
+		adjuster.adjustSchema(parameters)
+	}
+
+	return &funcTool[Args]{
+		declaration: &genai.FunctionDeclaration{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
 		},
-	},
+		fields: fields,
+		fn:     fn,
+	}
+}
+
+func (t *funcTool[Args]) Name() string {
+	return t.declaration.Name
+}
+
+func (t *funcTool[Args]) Declaration() *genai.FunctionDeclaration {
+	return t.declaration
+}
+
+func (t *funcTool[Args]) Execute(ctx context.Context, args map[string]any) (string, error) {
+	var decoded Args
+	if err := decodeArgs(t.fields, args, reflect.ValueOf(&decoded).Elem()); err != nil {
+		return "", err
+	}
+	return t.fn(ctx, decoded)
+}
+
+func argFields(t reflect.Type) ([]argField, error) {
+	var fields []argField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema, err := typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", field.Name, err)
+		}
+		schema.Description = field.Tag.Get("description")
+		if enum := field.Tag.Get("enum"); enum != "" {
+			if schema.Type != genai.TypeString {
+				return nil, fmt.Errorf("field %s: enum is only supported for strings", field.Name)
+			}
+			schema.Format = "enum"
+			schema.Enum = strings.Split(enum, ",")
+		}
+
+		fields = append(fields, argField{
+			name:     name,
+			index:    i,
+			required: !strings.Contains(options, "omitempty"),
+			schema:   schema,
+		})
+	}
+	return fields, nil
+}
+
+func objectSchema(fields []argField) *genai.Schema {
+	schema := &genai.Schema{
+		Type:       genai.TypeObject,
+		Properties: make(map[string]*genai.Schema, len(fields)),
+	}
+	for _, field := range fields {
+		schema.Properties[field.name] = field.schema
+		if field.required {
+			schema.Required = append(schema.Required, field.name)
+		}
+	}
+	return schema
+}
+
+func typeSchema(t reflect.Type) (*genai.Schema, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return &genai.Schema{Type: genai.TypeString}, nil
+	case reflect.Bool:
+		return &genai.Schema{Type: genai.TypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &genai.Schema{Type: genai.TypeInteger}, nil
+	case reflect.Float32, reflect.Float64:
+		return &genai.Schema{Type: genai.TypeNumber}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &genai.Schema{Type: genai.TypeArray, Items: items}, nil
+	case reflect.Struct:
+		fields, err := argFields(t)
+		if err != nil {
+			return nil, err
+		}
+		return objectSchema(fields), nil
+	case reflect.Pointer:
+		schema, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		schema.Nullable = true
+		return schema, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// decodeArgs decodes the model's arguments into v, the errors are meant for
+// the model so it can correct the call.
+func decodeArgs(fields []argField, args map[string]any, v reflect.Value) error {
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field.name] = true
+
+		value, ok := args[field.name]
+		if !ok || value == nil {
+			if field.required {
+				return fmt.Errorf("invalid or missing %s argument", field.name)
+			}
+			continue
+		}
+
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s argument: %v", field.name, err)
+		}
+		if err := json.Unmarshal(data, v.Field(field.index).Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return fmt.Errorf("invalid %s argument: expected %s, got %s", field.name, schemaTypeName(field.schema), typeErr.Value)
+			}
+			return fmt.Errorf("invalid %s argument: %v", field.name, err)
+		}
+
+		if s, ok := value.(string); ok && field.required && strings.TrimSpace(s) == "" {
+			return fmt.Errorf("invalid or missing %s argument", field.name)
+		}
+
+		// an empty optional string is left out like a missing one
+		if s, ok := value.(string); ok && len(field.schema.Enum) > 0 && (s != "" || field.required) && !slices.Contains(field.schema.Enum, s) {
+			return fmt.Errorf("invalid %s argument: expected one of %s, got %q", field.name, strings.Join(field.schema.Enum, ", "), s)
+		}
+	}
+
+	for name := range args {
+		if !known[name] {
+			return fmt.Errorf("unknown argument %s", name)
+		}
+	}
+	return nil
+}
+
+func schemaTypeName(schema *genai.Schema) string {
+	switch schema.Type {
+	case genai.TypeString:
+		return "a string"
+	case genai.TypeBoolean:
+		return "a boolean"
+	case genai.TypeInteger:
+		return "an integer"
+	case genai.TypeNumber:
+		return "a number"
+	case genai.TypeArray:
+		return "an array of " + strings.TrimPrefix(strings.TrimPrefix(schemaTypeName(schema.Items), "a "), "an ") + "s"
+	default:
+		return "an object"
+	}
 }
@@ -0,0 +1,155 @@
+package genai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// callTool runs a function call the way the handler does.
+func callTool(ctx context.Context, call genai.FunctionCall) (string, error) {
+	tool, err := getTool(call.Name)
+	if err != nil {
+		return "", err
+	}
+	return tool.Execute(ctx, call.Args)
+}
+
+type testPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y,omitempty"`
+}
+
+type testArgs struct {
+	Text    string      `json:"text" description:"Some text"`
+	Mode    string      `json:"mode,omitempty" enum:"fast,slow"`
+	Count   int         `json:"count,omitempty"`
+	Verbose bool        `json:"verbose,omitempty"`
+	Tags    []string    `json:"tags,omitempty"`
+	Points  []testPoint `json:"points,omitempty"`
+	ignored string
+}
+
+func TestNewToolDeclaration(t *testing.T) {
+	tool := NewTool("test", "A test tool", func(ctx context.Context, args testArgs) (string, error) {
+		return "", nil
+	})
+
+	want := &genai.FunctionDeclaration{
+		Name:        "test",
+		Description: "A test tool",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"text":    {Type: genai.TypeString, Description: "Some text"},
+				"mode":    {Type: genai.TypeString, Format: "enum", Enum: []string{"fast", "slow"}},
+				"count":   {Type: genai.TypeInteger},
+				"verbose": {Type: genai.TypeBoolean},
+				"tags":    {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+				"points": {Type: genai.TypeArray, Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"x": {Type: genai.TypeNumber},
+						"y": {Type: genai.TypeNumber},
+					},
+					Required: []string{"x"},
+				}},
+			},
+			Required: []string{"text"},
+		},
+	}
+
+	if got := tool.Declaration(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Declaration() = %+v, want %+v", got.Parameters, want.Parameters)
+	}
+}
+
+func TestToolExecuteDecodesArgs(t *testing.T) {
+	var got testArgs
+	tool := NewTool("test", "", func(ctx context.Context, args testArgs) (string, error) {
+		got = args
+		return "done", nil
+	})
+
+	result, err := tool.Execute(context.Background(), map[string]any{
+		"text":    "hello",
+		"mode":    "slow",
+		"count":   float64(3),
+		"verbose": true,
+		"tags":    []any{"a", "b"},
+		"points":  []any{map[string]any{"x": 1.5, "y": float64(2)}},
+	})
+	if err != nil || result != "done" {
+		t.Fatalf("Execute() = %q, %v", result, err)
+	}
+
+	want := testArgs{
+		Text:    "hello",
+		Mode:    "slow",
+		Count:   3,
+		Verbose: true,
+		Tags:    []string{"a", "b"},
+		Points:  []testPoint{{X: 1.5, Y: 2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestToolExecuteErrors(t *testing.T) {
+	tool := NewTool("test", "", func(ctx context.Context, args testArgs) (string, error) {
+		t.Error("tool ran with invalid arguments")
+		return "", nil
+	})
+
+	tests := []struct {
+		name   string
+		args   map[string]any
+		errMsg string
+	}{
+		{"missing", map[string]any{}, "invalid or missing text argument"},
+		{"null", map[string]any{"text": nil}, "invalid or missing text argument"},
+		{"blank", map[string]any{"text": "  "}, "invalid or missing text argument"},
+		{"wrong type", map[string]any{"text": float64(1)}, "invalid text argument: expected a string, got number"},
+		{"fraction", map[string]any{"text": "a", "count": 1.5}, "invalid count argument: expected an integer"},
+		{"array items", map[string]any{"text": "a", "tags": []any{"a", true}}, "invalid tags argument: expected an array of strings, got bool"},
+		{"unknown", map[string]any{"text": "a", "colour": "red"}, "unknown argument colour"},
+		{"enum", map[string]any{"text": "a", "mode": "medium"}, `invalid mode argument: expected one of fast, slow, got "medium"`},
+		{"enum case", map[string]any{"text": "a", "mode": "Fast"}, "invalid mode argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tool.Execute(context.Background(), tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRegisteredTools(t *testing.T) {
+	var names []string
+	for _, declaration := range toolDeclarations().FunctionDeclarations {
+		names = append(names, declaration.Name)
+	}
+	want := []string{"read_file", "create_file", "web_search", "extract_websites"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("declared tools = %v, want %v", names, want)
+	}
+
+	createFile, _ := getTool("create_file")
+	if enum := createFile.Declaration().Parameters.Properties["file_extension"].Enum; !reflect.DeepEqual(enum, createFileExtensions) {
+		t.Errorf("file_extension enum = %v, want %v", enum, createFileExtensions)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a tool twice didn't panic")
+		}
+	}()
+	Register(createFile)
+}
@@ -0,0 +1,157 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrPoolBusy is returned by Submit when every worker is busy and the
+	// pending queue is full.
+	ErrPoolBusy = errors.New("worker pool is busy")
+	// ErrPoolClosed is returned by Submit once Shutdown was called.
+	ErrPoolClosed = errors.New("worker pool is shut down")
+)
+
+// WorkerPool runs jobs on a fixed number of goroutines. Jobs wait in a
+// bounded queue while every worker is busy, when the queue is full they are
+// turned away so a burst of messages can't start unlimited Gemini calls.
+type WorkerPool struct {
+	// room for every running and queued job, so sending never blocks
+	jobs      chan func()
+	workers   int
+	queueSize int
+	wg        sync.WaitGroup
+
+	mu sync.Mutex
+	// jobs submitted and not finished yet, running or queued
+	pending int
+	closed  bool
+
+	busy      atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+}
+
+// PoolStats is a snapshot of a WorkerPool for metrics.
+type PoolStats struct {
+	Workers   int   `json:"workers"`
+	Busy      int64 `json:"busy"`
+	Queued    int   `json:"queued"`
+	QueueSize int   `json:"queue_size"`
+	Completed int64 `json:"completed"`
+	Rejected  int64 `json:"rejected"`
+}
+
+// NewWorkerPool starts workers goroutines that run jobs one at a time, with
+// up to queueSize jobs waiting for a free worker.
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &WorkerPool{
+		jobs:      make(chan func(), workers+queueSize),
+		workers:   workers,
+		queueSize: queueSize,
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		p.run(job)
+	}
+}
+
+func (p *WorkerPool) run(job func()) {
+	p.busy.Add(1)
+	defer func() {
+		p.mu.Lock()
+		p.pending--
+		p.mu.Unlock()
+		p.busy.Add(-1)
+		p.completed.Add(1)
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in worker: %v", r)
+		}
+	}()
+	job()
+}
+
+// Submit queues job for the next free worker. It doesn't block, when the
+// queue is full it returns ErrPoolBusy.
+func (p *WorkerPool) Submit(job func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if p.pending >= p.workers+p.queueSize {
+		p.rejected.Add(1)
+		return ErrPoolBusy
+	}
+
+	p.pending++
+	p.jobs <- job
+	return nil
+}
+
+// Stats returns the current state of the pool.
+func (p *WorkerPool) Stats() PoolStats {
+	p.mu.Lock()
+	pending := p.pending
+	p.mu.Unlock()
+
+	busy := p.busy.Load()
+	queued := pending - int(busy)
+	if queued < 0 {
+		// a finished job is counted as busy for a moment longer
+		queued = 0
+	}
+
+	return PoolStats{
+		Workers:   p.workers,
+		Busy:      busy,
+		Queued:    queued,
+		QueueSize: p.queueSize,
+		Completed: p.completed.Load(),
+		Rejected:  p.rejected.Load(),
+	}
+}
+
+// Shutdown stops accepting jobs and waits for the queued and running ones
+// to finish, or for ctx to be done.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
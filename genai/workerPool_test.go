@@ -0,0 +1,87 @@
+package genai
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolBackpressure(t *testing.T) {
+	p := NewWorkerPool(2, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	var ran atomic.Int64
+	blocking := func() {
+		started <- struct{}{}
+		<-release
+		ran.Add(1)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.Submit(blocking); err != nil {
+			t.Fatalf("submit %d: %v", i, err)
+		}
+	}
+	<-started
+	<-started
+
+	if err := p.Submit(func() { ran.Add(1) }); err != nil {
+		t.Fatalf("queueing with room left: %v", err)
+	}
+	if err := p.Submit(func() { ran.Add(1) }); !errors.Is(err, ErrPoolBusy) {
+		t.Fatalf("submit to a saturated pool: err %v", err)
+	}
+
+	stats := p.Stats()
+	if stats.Busy != 2 || stats.Queued != 1 || stats.Rejected != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// queued jobs are run before shutdown returns
+	if ran.Load() != 3 {
+		t.Errorf("ran %d jobs, want 3", ran.Load())
+	}
+	if err := p.Submit(func() {}); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("submit after shutdown: err %v", err)
+	}
+}
+
+func TestWorkerPoolShutdownTimeout(t *testing.T) {
+	p := NewWorkerPool(1, 0)
+
+	release := make(chan struct{})
+	defer close(release)
+	if err := p.Submit(func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown with a running job: err %v", err)
+	}
+}
+
+func TestWorkerPoolRecoversPanics(t *testing.T) {
+	p := NewWorkerPool(1, 1)
+
+	done := make(chan struct{})
+	p.Submit(func() { panic("boom") })
+	p.Submit(func() { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker didn't survive a panicking job")
+	}
+}
@@ -3,9 +3,12 @@ module google_genai
 go 1.23.1
 
 require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/PuerkitoBio/goquery v1.10.0
 	github.com/google/generative-ai-go v0.19.0
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
+	golang.org/x/net v0.33.0
 	google.golang.org/api v0.214.0
-	github.com/PuerkitoBio/goquery v1.10.0
 )
 
 require (
@@ -29,7 +32,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.29.0 // indirect
 	go.opentelemetry.io/otel/trace v1.29.0 // indirect
 	golang.org/x/crypto v0.31.0 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/oauth2 v0.24.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
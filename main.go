@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"google_genai/genai"
 	"google_genai/telegram"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -17,50 +27,289 @@ func main() {
 		log.Fatal("BOT_TOKEN environment variable is not set")
 	}
 
-	if os.Getenv("WEBHOOK_URL") == "" {
-		log.Fatal("WEBHOOK_URL environment variable is not set")
+	// UPDATE_MODE is either "webhook" (default) or "polling"
+	mode := os.Getenv("UPDATE_MODE")
+	if mode == "" {
+		mode = "webhook"
+	}
+
+	if mode != "webhook" && mode != "polling" {
+		log.Fatalf("Invalid UPDATE_MODE %q, expected \"webhook\" or \"polling\"", mode)
 	}
 
-	if os.Getenv("PORT") == "" {
+	if mode == "webhook" && os.Getenv("WEBHOOK_URL") == "" {
 		log.Fatal("WEBHOOK_URL environment variable is not set")
 	}
 
+	// HISTORY_STORE is either "memory" (default) or "file"
+	if os.Getenv("HISTORY_STORE") == "file" {
+		historyDir := os.Getenv("HISTORY_DIR")
+		if historyDir == "" {
+			historyDir = "synapse_history"
+		}
+
+		store, err := genai.NewFileHistoryStore(historyDir)
+		if err != nil {
+			log.Fatal("Error creating history store:", err)
+		}
+		genai.SetHistoryStore(store)
+	}
+
+	// SEARCH_PROVIDERS is a comma separated fallback order, e.g. "brave,duckduckgo,google"
+	if names := os.Getenv("SEARCH_PROVIDERS"); names != "" {
+		options := map[string]string{
+			"searxng": os.Getenv("SEARXNG_URL"),
+			"brave":   os.Getenv("BRAVE_API_KEY"),
+			"bing":    os.Getenv("BING_API_KEY"),
+		}
+
+		var providers []genai.SearchProvider
+		for _, name := range strings.Split(names, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			provider, err := genai.NewSearchProvider(name, options[name])
+			if err != nil {
+				log.Fatal("Error configuring search provider:", err)
+			}
+			providers = append(providers, provider)
+		}
+		genai.SetSearchProviders(providers...)
+	}
+
+	// URL_ALLOWLIST and URL_DENYLIST are comma separated host names, IPs or
+	// CIDR ranges, names and IPs optionally with a port. Search and scraping
+	// only reach public addresses unless allowed, a SearXNG instance is
+	// allowed automatically on its own port only, so other services on its
+	// host stay out of reach.
+	allow := splitList(os.Getenv("URL_ALLOWLIST"))
+	if searxURL := os.Getenv("SEARXNG_URL"); searxURL != "" {
+		if u, err := url.Parse(searxURL); err == nil && u.Hostname() != "" {
+			port := u.Port()
+			if port == "" {
+				port = "80"
+				if u.Scheme == "https" {
+					port = "443"
+				}
+			}
+			allow = append(allow, net.JoinHostPort(u.Hostname(), port))
+		}
+	}
+	policy, err := genai.NewURLPolicy(allow, splitList(os.Getenv("URL_DENYLIST")))
+	if err != nil {
+		log.Fatal("Error configuring URL policy:", err)
+	}
+	genai.SetURLPolicy(policy)
+
 	bot := telegram.NewBot(os.Getenv("BOT_TOKEN"))
 
-	genAIHandler := genai.NewHandler(bot)
+	// CONFIG_FILE is a TOML file with the model, generation parameters,
+	// safety thresholds, system prompt and tools, see genai.Config
+	config, err := genai.LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+	log.Printf("Config: %s", config.Summary())
+	genai.SetDisabledTools(config.DisabledTools()...)
 
-	err := bot.SetWebhook(os.Getenv("WEBHOOK_URL"))
+	genAIHandler, err := genai.NewHandler(bot, os.Getenv("GEMINI_API_KEY"), config)
 	if err != nil {
-		log.Fatal("Error setting webhook:", err)
+		log.Fatal("Error creating handler:", err)
+	}
+	defer genAIHandler.Close()
+	bot.OnCancel = genAIHandler.CancelProcessing
+	if os.Getenv("STREAM_RESPONSES") == "true" {
+		genAIHandler.EnableStreaming()
+	}
+	if os.Getenv("HISTORY_TOKEN_COUNTER") == "model" {
+		genAIHandler.EnableModelTokenCounting()
+	}
+	if os.Getenv("SUMMARIZE_HISTORY") == "true" {
+		genAIHandler.EnableSummarization()
 	}
 
-	http.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
-		update, err := bot.ParseUpdate(r)
+	if budget := os.Getenv("HISTORY_TOKEN_BUDGET"); budget != "" {
+		n, err := strconv.Atoi(budget)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid HISTORY_TOKEN_BUDGET %q", budget)
+		}
+		genai.SetHistoryTokenBudget(n)
+	}
+
+	// HTTP_CACHE_DIR enables the on-disk cache for search and scraping
+	if cacheDir := os.Getenv("HTTP_CACHE_DIR"); cacheDir != "" {
+		maxMB := 100
+		if v := os.Getenv("HTTP_CACHE_MAX_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid HTTP_CACHE_MAX_MB %q", v)
+			}
+			maxMB = n
+		}
+
+		cache, err := genai.NewHTTPCache(cacheDir, int64(maxMB)<<20)
 		if err != nil {
-			log.Printf("Error parsing update: %v", err)
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-			return
+			log.Fatal("Error creating http cache:", err)
 		}
+		genai.SetHTTPCache(cache)
 
-		if update.Message != nil {
-			if len(update.Message.Entities) > 0 && update.Message.Entities[0].Type == "bot_command" {
-				bot.HandleCommands(update.Message.Chat.ID, update.Message.Text)
-			} else {
-				chatID := update.Message.Chat.ID
-				text := update.Message.Text
+		if v := os.Getenv("HTTP_CACHE_SEARCH_TTL"); v != "" {
+			ttl, err := time.ParseDuration(v)
+			if err != nil || ttl < 0 {
+				log.Fatalf("Invalid HTTP_CACHE_SEARCH_TTL %q", v)
+			}
+			genai.SetSearchCacheTTL(ttl)
+		}
+		if v := os.Getenv("HTTP_CACHE_PAGE_TTL"); v != "" {
+			ttl, err := time.ParseDuration(v)
+			if err != nil || ttl < 0 {
+				log.Fatalf("Invalid HTTP_CACHE_PAGE_TTL %q", v)
+			}
+			genai.SetPageCacheTTL(ttl)
+		}
+	}
 
-				log.Printf("ChatId: %d \nText: %s", chatID, text)
+	// POLITE_SCRAPING makes extract_websites honor robots.txt and space out
+	// requests to the same host
+	if os.Getenv("POLITE_SCRAPING") == "true" {
+		userAgent := os.Getenv("SCRAPER_USER_AGENT")
+		if userAgent == "" {
+			userAgent = "SynapseBot/1.0"
+		}
 
-				messageId, err := bot.SendLoadingMessage(chatID, "⏳")
-				if err != nil {
-					log.Println("Error sending loading message:", err)
-				}
+		delay := time.Second
+		if v := os.Getenv("SCRAPER_HOST_DELAY"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				log.Fatalf("Invalid SCRAPER_HOST_DELAY %q", v)
+			}
+			delay = d
+		}
+
+		genai.SetPoliteScraper(genai.NewPoliteScraper(userAgent, delay))
+	}
+
+	// MAX_TOOL_ROUNDS and REQUEST_TIMEOUT bound the work done for one message
+	if v := os.Getenv("MAX_TOOL_ROUNDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MAX_TOOL_ROUNDS %q", v)
+		}
+		genai.SetMaxToolRounds(n)
+	}
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			log.Fatalf("Invalid REQUEST_TIMEOUT %q", v)
+		}
+		genai.SetRequestTimeout(timeout)
+	}
 
-				log.Printf("Loading message ID: %d", messageId)
+	// MAX_QUEUED_MESSAGES is how many messages of a chat may wait, default 5
+	if v := os.Getenv("MAX_QUEUED_MESSAGES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MAX_QUEUED_MESSAGES %q", v)
+		}
+		genai.SetMaxQueuedMessages(n)
+	}
 
-				go genAIHandler.ProcessMessage(text, chatID, messageId)
+	// EXTRACT_TOP_N is how many search results web_search extracts, default 5
+	if topN := os.Getenv("EXTRACT_TOP_N"); topN != "" {
+		n, err := strconv.Atoi(topN)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid EXTRACT_TOP_N %q", topN)
+		}
+		genai.SetExtractTopN(n)
+	}
+
+	// WORKERS messages are answered at once, up to WORKER_QUEUE_SIZE more wait
+	// for a free worker and the rest are turned away
+	workers, queueSize := 8, 32
+	if v := os.Getenv("WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid WORKERS %q", v)
+		}
+		workers = n
+	}
+	if v := os.Getenv("WORKER_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid WORKER_QUEUE_SIZE %q", v)
+		}
+		queueSize = n
+	}
+	pool := genai.NewWorkerPool(workers, queueSize)
+	genAIHandler.SetWorkerPool(pool)
+	expvar.Publish("worker_pool", expvar.Func(func() any { return pool.Stats() }))
+
+	// METRICS_ADDR serves /debug/vars, keep it private, e.g. "localhost:9090".
+	// The webhook server doesn't serve metrics.
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		metrics := http.NewServeMux()
+		metrics.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Printf("Serving metrics on %s/debug/vars", addr)
+			if err := http.ListenAndServe(addr, metrics); err != nil {
+				log.Printf("Error serving metrics: %v", err)
 			}
+		}()
+	}
+
+	// SHUTDOWN_TIMEOUT is how long running and queued messages get to finish
+	shutdownTimeout := time.Minute
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT %q", v)
+		}
+		shutdownTimeout = d
+	}
+
+	cleanup := genai.NewCleanupService("synapse_files")
+	cleanup.Start()
+	defer cleanup.Stop()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// answers already accepted are finished before exiting
+	defer func() {
+		stats := pool.Stats()
+		log.Printf("Waiting up to %v for %d running and %d queued messages", shutdownTimeout, stats.Busy, stats.Queued)
+		drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := pool.Shutdown(drainCtx); err != nil {
+			log.Printf("Stopped before every message was answered: %v", err)
 		}
+	}()
+
+	if mode == "polling" {
+		// getUpdates doesn't work while a webhook is set
+		if err := bot.DeleteWebhook(); err != nil {
+			log.Fatal("Error deleting webhook:", err)
+		}
+
+		bot.StartPolling(ctx, func(update *telegram.Update) {
+			handleUpdate(bot, genAIHandler, pool, update)
+		})
+		return
+	}
+
+	err = bot.SetWebhook(os.Getenv("WEBHOOK_URL"))
+	if err != nil {
+		log.Fatal("Error setting webhook:", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		update, err := bot.ParseUpdate(r)
+		if err != nil {
+			log.Printf("Error parsing update: %v", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		handleUpdate(bot, genAIHandler, pool, update)
 
 		w.WriteHeader(http.StatusOK)
 	})
@@ -70,10 +319,134 @@ func main() {
 		port = "8080"
 	}
 
-	cleanup := genai.NewCleanupService("synapse_files")
-	cleanup.Start()
-	defer cleanup.Stop()
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		// stop taking updates, the pool is drained once main returns
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
 
 	log.Printf("Starting server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+func handleUpdate(bot *telegram.Bot, genAIHandler *genai.Handler, pool *genai.WorkerPool, update *telegram.Update) {
+	if update.Message == nil {
+		return
+	}
+
+	if len(update.Message.Entities) > 0 && update.Message.Entities[0].Type == "bot_command" {
+		bot.HandleCommands(update.Message.Chat.ID, update.Message.Text)
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	text := update.Message.Text
+	if text == "" {
+		text = update.Message.Caption
+	}
+
+	files := messageFiles(update.Message)
+	if text == "" && len(files) == 0 {
+		// stickers, locations etc.
+		return
+	}
+
+	log.Printf("ChatId: %d \nText: %s \nFiles: %d", chatID, text, len(files))
+
+	messageId, err := bot.SendLoadingMessage(chatID, "⏳")
+	if err != nil {
+		log.Println("Error sending loading message:", err)
+	}
+
+	log.Printf("Loading message ID: %d", messageId)
+
+	err = pool.Submit(func() {
+		attachments := make([]genai.Attachment, 0, len(files))
+		for _, f := range files {
+			if f.size > telegram.MaxDownloadSize {
+				bot.HandleUpdateMessage(chatID, messageId, "Sorry, that file is too large. Telegram lets me download files up to 20 MB.")
+				return
+			}
+
+			data, err := bot.DownloadFile(f.fileID)
+			if err != nil {
+				log.Printf("Error downloading file %s: %v", f.fileID, err)
+				bot.HandleUpdateMessage(chatID, messageId, "Sorry, I couldn't download your file. Please try again.")
+				return
+			}
+
+			attachments = append(attachments, genai.Attachment{
+				FileName: f.name,
+				MIMEType: f.mimeType,
+				Data:     data,
+				Document: f.document,
+			})
+		}
+
+		genAIHandler.ProcessMessage(text, chatID, messageId, attachments...)
+	})
+	switch {
+	case errors.Is(err, genai.ErrPoolBusy):
+		log.Printf("Worker pool is full, turning away a message of chat %d", chatID)
+		bot.HandleUpdateMessage(chatID, messageId, "I'm answering a lot of messages right now, please try again in a minute.")
+	case errors.Is(err, genai.ErrPoolClosed):
+		bot.HandleUpdateMessage(chatID, messageId, "I'm restarting, please send your message again in a minute.")
+	}
+}
+
+type messageFile struct {
+	fileID   string
+	name     string
+	mimeType string
+	size     int
+	document bool
+}
+
+// messageFiles lists the media attached to a message, for photos only the
+// largest size is used.
+func messageFiles(msg *telegram.Message) []messageFile {
+	var files []messageFile
+
+	if len(msg.Photo) > 0 {
+		photo := msg.Photo[len(msg.Photo)-1]
+		files = append(files, messageFile{photo.FileID, "photo.jpg", "image/jpeg", photo.FileSize, false})
+	}
+
+	if d := msg.Document; d != nil {
+		files = append(files, messageFile{d.FileID, d.FileName, d.MimeType, d.FileSize, true})
+	}
+
+	if v := msg.Voice; v != nil {
+		mimeType := v.MimeType
+		if mimeType == "" {
+			mimeType = "audio/ogg"
+		}
+		files = append(files, messageFile{v.FileID, "voice.ogg", mimeType, v.FileSize, false})
+	}
+
+	if a := msg.Audio; a != nil {
+		files = append(files, messageFile{a.FileID, a.FileName, a.MimeType, a.FileSize, false})
+	}
+
+	if v := msg.Video; v != nil {
+		files = append(files, messageFile{v.FileID, v.FileName, v.MimeType, v.FileSize, false})
+	}
+
+	return files
+}
+
+// splitList splits a comma separated environment variable.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -16,10 +16,13 @@ Hi there! I'm **Synapse**, your versatile assistant. Here's what I can do for yo
 
 ✨ **Features and Capabilities**
 
-	 **Create File**: Create new files ( For now, only **.txt** files are supported, other formats are coming soon! ).
-	 || **Read File**: Read file ( **Comming Soon** ). ||
+	 **Create File**: Create new files ( **.txt**, **.md**, **.csv**, **.json**, **.html**, **.pdf** and source code ).
+	 **Read File**: Send me a document (pdf, docx, txt, csv, code...) and I can read it for you.
 	 **Web Search**: Retrieve relevant information from the web.
 	 **Content Extraction**: Extract data from websites.
+	 **Photos, Documents & Voice**: Send me a photo, PDF or voice note and ask about it.
+
+Send **/cancel** to stop an answer that is taking too long.
 
 **Need Help or Have Suggestions?**
 Feel free to reach out anytime via [@harsh](https://t.me/harsh_693).
@@ -32,19 +35,24 @@ const privacyPolicy = `
 
 * Synapse uses your chat ID and text to respond.
 
-* I temporarily store the last 10 messages for context,
-but no data is permanently saved.
+* I store your recent messages for context. Depending on
+how I'm deployed they may be kept on disk between restarts.
 `
 
 type Bot struct {
-	Token      string
-	APIBaseURL string
+	Token       string
+	APIBaseURL  string
+	FileBaseURL string
+	// OnCancel stops the request being answered in a chat for /cancel and
+	// reports whether there was one
+	OnCancel func(chatID int) bool
 }
 
 func NewBot(token string) *Bot {
 	return &Bot{
-		Token:      token,
-		APIBaseURL: "https://api.telegram.org/bot" + token,
+		Token:       token,
+		APIBaseURL:  "https://api.telegram.org/bot" + token,
+		FileBaseURL: "https://api.telegram.org/file/bot" + token,
 	}
 }
 
@@ -87,6 +95,11 @@ func (b *Bot) HandleCommands(chatId int, text string) error {
 		b.SendMessage(chatId, helpGuide)
 	case text == "/privacy":
 		b.SendMessage(chatId, privacyPolicy)
+	case text == "/cancel":
+		// a cancelled request says so in its own message
+		if b.OnCancel == nil || !b.OnCancel(chatId) {
+			b.SendMessage(chatId, "Nothing to cancel.")
+		}
 	case strings.HasPrefix(text, "/"):
 		b.SendMessage(chatId, "Not a vaild command. Type **/help** to see the list of available commands.")
 	}
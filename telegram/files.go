@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// MaxDownloadSize is the largest file the Bot API lets bots download.
+const MaxDownloadSize = 20 << 20
+
+func (b *Bot) GetFile(fileID string) (*File, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/getFile?file_id=%s", b.APIBaseURL, url.QueryEscape(fileID)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Ok          bool   `json:"ok"`
+		Result      File   `json:"result"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("status %d: error decoding file: %v", resp.StatusCode, err)
+	}
+
+	if !result.Ok {
+		return nil, &TelegramError{
+			Ok:          result.Ok,
+			ErrorCode:   result.ErrorCode,
+			Description: result.Description,
+		}
+	}
+
+	return &result.Result, nil
+}
+
+// DownloadFile fetches the content of a file sent to the bot.
+func (b *Bot) DownloadFile(fileID string) ([]byte, error) {
+	file, err := b.GetFile(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting file: %v", err)
+	}
+
+	if file.FileSize > MaxDownloadSize {
+		return nil, fmt.Errorf("file is too large: %d bytes", file.FileSize)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/%s", b.FileBaseURL, file.FilePath))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading file: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+
+	if len(data) > MaxDownloadSize {
+		return nil, fmt.Errorf("file is too large")
+	}
+
+	return data, nil
+}
@@ -8,11 +8,14 @@ import (
 	"google_genai/format"
 	"io"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type TelegramError struct {
@@ -287,7 +290,15 @@ func (b *Bot) SendDocument(chatID int, filePath string) error {
 	}
 	defer file.Close()
 
-	part, err := writer.CreateFormFile("document", filepath.Base(filePath))
+	fileName := filepath.Base(filePath)
+	contentType := documentContentType(fileName)
+
+	// CreateFormFile always sends application/octet-stream
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="document"; filename="%s"`, escapeQuotes(fileName)))
+	header.Set("Content-Type", contentType)
+
+	part, err := writer.CreatePart(header)
 	if err != nil {
 		return fmt.Errorf("error creating form file: %v", err)
 	}
@@ -330,6 +341,45 @@ func (b *Bot) SendDocument(chatID int, filePath string) error {
 	return nil
 }
 
+// types the mime package doesn't know without a system mime.types file
+var documentTypes = map[string]string{
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".yaml":  "application/yaml",
+	".go":    "text/x-go; charset=utf-8",
+	".py":    "text/x-python; charset=utf-8",
+	".ts":    "text/plain; charset=utf-8",
+	".java":  "text/x-java; charset=utf-8",
+	".c":     "text/x-c; charset=utf-8",
+	".cpp":   "text/x-c++; charset=utf-8",
+	".h":     "text/x-c; charset=utf-8",
+	".rs":    "text/x-rust; charset=utf-8",
+	".rb":    "text/x-ruby; charset=utf-8",
+	".php":   "text/x-php; charset=utf-8",
+	".sh":    "text/x-shellscript; charset=utf-8",
+	".sql":   "application/sql",
+	".kt":    "text/plain; charset=utf-8",
+	".swift": "text/plain; charset=utf-8",
+}
+
+func documentContentType(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if contentType, ok := documentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
 func (b *Bot) SendFileWithProgress(chatID int, filePath string) error {
 	if filePath == "" {
 		return fmt.Errorf("invalid file path")
@@ -0,0 +1,122 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	pollTimeout    = 30 // seconds, passed to getUpdates as long-poll timeout
+	minPollBackoff = time.Second
+	maxPollBackoff = 30 * time.Second
+)
+
+type getUpdatesResponse struct {
+	Ok          bool     `json:"ok"`
+	Result      []Update `json:"result"`
+	ErrorCode   int      `json:"error_code"`
+	Description string   `json:"description"`
+}
+
+func (b *Bot) DeleteWebhook() error {
+	url := fmt.Sprintf("%s/deleteWebhook", b.APIBaseURL)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete webhook: %s", body)
+	}
+	return nil
+}
+
+func (b *Bot) GetUpdates(ctx context.Context, offset int, timeout int) ([]Update, error) {
+	params := url.Values{}
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("timeout", strconv.Itoa(timeout))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", b.APIBaseURL+"/getUpdates?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the long poll holds the connection open for `timeout` seconds,
+	// so the client timeout has to be longer than that
+	client := &http.Client{Timeout: time.Duration(timeout+10) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result getUpdatesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("status %d: error decoding updates: %v", resp.StatusCode, err)
+	}
+
+	if !result.Ok {
+		return nil, &TelegramError{
+			Ok:          result.Ok,
+			ErrorCode:   result.ErrorCode,
+			Description: result.Description,
+		}
+	}
+
+	return result.Result, nil
+}
+
+// StartPolling long-polls getUpdates until ctx is done and passes every
+// update to handle. The offset is advanced past each received update so
+// Telegram doesn't deliver it again.
+func (b *Bot) StartPolling(ctx context.Context, handle func(update *Update)) {
+	offset := 0
+	backoff := minPollBackoff
+
+	log.Println("Polling for updates...")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Polling stopped")
+			return
+		default:
+		}
+
+		updates, err := b.GetUpdates(ctx, offset, pollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+
+			log.Printf("Error getting updates: %v (retrying in %v)", err, backoff)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxPollBackoff {
+				backoff = maxPollBackoff
+			}
+			continue
+		}
+		backoff = minPollBackoff
+
+		for i := range updates {
+			if updates[i].UpdateID >= offset {
+				offset = updates[i].UpdateID + 1
+			}
+			handle(&updates[i])
+		}
+	}
+}